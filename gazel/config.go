@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/yugui/gazel/generator"
+)
+
+// configFile is the name of the project configuration file in the base dir.
+const configFile = ".gazel.json"
+
+// config is a project-wide configuration of gazel.
+// It is read from the configuration file, and command-line flags override it.
+type config struct {
+	GoPrefix            string            `json:"go_prefix"`
+	Mode                string            `json:"mode"`
+	Flat                bool              `json:"flat"`
+	Naming              string            `json:"naming"`
+	BuildTags           []string          `json:"build_tags"`
+	Excludes            []string          `json:"excludes"`
+	KnownImports        map[string]string `json:"known_imports"`
+	GoVersion           string            `json:"go_version"`
+	PureCondition       string            `json:"pure_condition"`
+	GoVersionConditions []string          `json:"go_version_conditions"`
+	Platforms           []string          `json:"platforms"`
+	RepoRoots           []string          `json:"repo_roots"`
+	CDepsMap            string            `json:"cdeps_map"`
+	SwigTool            string            `json:"swig_tool"`
+	DefaultVisibility   []string          `json:"default_visibility"`
+	Licenses            []string          `json:"licenses"`
+	MinimalVisibility   bool              `json:"minimal_visibility"`
+}
+
+// loadConfig returns the configuration for the base dir "base".
+// It reads the file specified by -config, or the configuration file in "base"
+// if exists, and then applies command-line flags given explicitly.
+// Relative paths in the file are relative to "base".
+func loadConfig(base string) (*config, error) {
+	c := &config{Mode: "print"}
+	// explicitPure is true if -pure_condition is given explicitly.
+	var explicitPure bool
+
+	fname := *configPath
+	if fname == "" {
+		fname = filepath.Join(base, configFile)
+	}
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil && (*configPath != "" || !os.IsNotExist(err)) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(buf, c); err != nil {
+			return nil, fmt.Errorf("%s: %v", fname, err)
+		}
+		var keys map[string]json.RawMessage
+		if err := json.Unmarshal(buf, &keys); err != nil {
+			return nil, fmt.Errorf("%s: %v", fname, err)
+		}
+		_, explicitPure = keys["pure_condition"]
+		if c.CDepsMap != "" && !filepath.IsAbs(c.CDepsMap) {
+			c.CDepsMap = filepath.Join(base, c.CDepsMap)
+		}
+	}
+
+	var errs []string
+	flag.Visit(func(f *flag.Flag) {
+		if err := c.applyFlag(f.Name); err != nil {
+			errs = append(errs, err.Error())
+		}
+		explicitPure = explicitPure || f.Name == "pure_condition"
+	})
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	// The pure variant is opt-in with -platform because their config_settings
+	// are not exclusive.
+	if !explicitPure && len(c.Platforms) == 0 {
+		c.PureCondition = defaultPureCondition
+	}
+
+	switch c.Mode {
+	case "print", "fix", "diff":
+	default:
+		return nil, fmt.Errorf("unrecognized mode %q", c.Mode)
+	}
+	return c, nil
+}
+
+// applyFlag overrides "c" with the value of the command-line flag "name".
+func (c *config) applyFlag(name string) error {
+	switch name {
+	case "go_prefix":
+		c.GoPrefix = *goPrefix
+	case "mode":
+		c.Mode = *mode
+	case "flat":
+		c.Flat = *flat
+	case "naming":
+		c.Naming = *naming
+	case "build_tags":
+		c.BuildTags = splitList(*buildTags)
+	case "exclude":
+		c.Excludes = excludes
+	case "known_import":
+		c.KnownImports = make(map[string]string)
+		for _, kv := range knownImports {
+			i := strings.Index(kv, "=")
+			if i < 0 {
+				return fmt.Errorf("malformed -known_import %q; want IMPORTPATH=LABEL", kv)
+			}
+			c.KnownImports[kv[:i]] = kv[i+1:]
+		}
+	case "go_version":
+		c.GoVersion = *goVersion
+	case "pure_condition":
+		c.PureCondition = *pureCond
+	case "go_version_condition":
+		c.GoVersionConditions = goVersionConditions
+	case "platform":
+		c.Platforms = platforms
+	case "repo_root":
+		c.RepoRoots = repoRoots
+	case "cdeps_map":
+		c.CDepsMap = *cdepsMap
+	case "swig_tool":
+		c.SwigTool = *swigTool
+	case "default_visibility":
+		c.DefaultVisibility = splitList(*defaultVisibility)
+	case "licenses":
+		c.Licenses = splitList(*licenses)
+	case "minimal_visibility":
+		c.MinimalVisibility = *minimalVis
+	}
+	return nil
+}
+
+// baseConfig returns the configuration of the base dir "base", which
+// reflects "c" and directives in the BUILD file in "base".
+func (c *config) baseConfig(base string) (*generator.Config, error) {
+	var ds []generator.Directive
+	if c.Naming != "" {
+		ds = append(ds, generator.Directive{Key: "naming", Value: c.Naming})
+	}
+	if len(c.BuildTags) > 0 {
+		ds = append(ds, generator.Directive{Key: "build_tags", Value: strings.Join(c.BuildTags, ",")})
+	}
+	root, err := (*generator.Config)(nil).Inherit(ds)
+	if err != nil {
+		return nil, err
+	}
+	bc, err := generator.LoadConfig(base, root)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range c.Excludes {
+		if bc.Exclude == nil {
+			bc.Exclude = make(map[string]bool)
+		}
+		bc.Exclude[path.Clean(filepath.ToSlash(p))] = true
+	}
+	return bc, nil
+}
+
+// splitList splits a comma-separated list given by a flag.
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+)
+
+// setFlags sets command-line flags as if they were given explicitly.
+// The returned function restores the flags.
+func setFlags(t *testing.T, flags map[string]string) func() {
+	orig := flag.CommandLine
+	fs := flag.NewFlagSet(orig.Name(), flag.ContinueOnError)
+	orig.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	flag.CommandLine = fs
+	restore := func() {
+		fs.Visit(func(f *flag.Flag) {
+			if v, ok := f.Value.(*stringsFlag); ok {
+				*v = nil
+				return
+			}
+			f.Value.Set(f.DefValue)
+		})
+		flag.CommandLine = orig
+	}
+	for name, value := range flags {
+		if err := fs.Set(name, value); err != nil {
+			restore()
+			t.Fatalf("fs.Set(%q, %q) failed with %v; want success", name, value, err)
+		}
+	}
+	return restore
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		configFile: `{
+  "go_prefix": "example.com/repo",
+  "mode": "fix",
+  "flat": true,
+  "naming": "import",
+  "build_tags": ["extra"],
+  "excludes": ["p/skip.go"],
+  "known_imports": {"example.com/ext": "//third_party/ext"},
+  "go_version": "1.9",
+  "go_version_conditions": ["1.9=//config:go1.9"],
+  "repo_roots": ["^(example\\.com/[^/]+)"],
+  "cdeps_map": "cdeps.txt",
+  "swig_tool": "//third_party/swig",
+  "default_visibility": ["//visibility:public"],
+  "licenses": ["notice"],
+  "minimal_visibility": true
+}`,
+	})
+
+	c, err := loadConfig(dir)
+	if err != nil {
+		t.Fatalf("loadConfig(%q) failed with %v; want success", dir, err)
+	}
+	want := &config{
+		GoPrefix:            "example.com/repo",
+		Mode:                "fix",
+		Flat:                true,
+		Naming:              "import",
+		BuildTags:           []string{"extra"},
+		Excludes:            []string{"p/skip.go"},
+		KnownImports:        map[string]string{"example.com/ext": "//third_party/ext"},
+		GoVersion:           "1.9",
+		PureCondition:       defaultPureCondition,
+		GoVersionConditions: []string{"1.9=//config:go1.9"},
+		RepoRoots:           []string{`^(example\.com/[^/]+)`},
+		CDepsMap:            filepath.Join(dir, "cdeps.txt"),
+		SwigTool:            "//third_party/swig",
+		DefaultVisibility:   []string{"//visibility:public"},
+		Licenses:            []string{"notice"},
+		MinimalVisibility:   true,
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("loadConfig(%q) = %#v; want %#v", dir, c, want)
+	}
+}
+
+func TestGenerateWithConfigFile(t *testing.T) {
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		configFile: `{
+  "go_prefix": "example.com/repo",
+  "naming": "import",
+  "excludes": ["p/skip.go"],
+  "known_imports": {"example.com/ext": "//third_party/ext"},
+  "default_visibility": ["//visibility:public"],
+  "licenses": ["notice"]
+}`,
+		"p/p.go":    "package p\n\nimport _ \"example.com/ext\"\n",
+		"p/skip.go": "package p\n",
+	})
+
+	c, err := loadConfig(dir)
+	if err != nil {
+		t.Fatalf("loadConfig(%q) failed with %v; want success", dir, err)
+	}
+	g, emitted := newTestGen(t, dir, c)
+	if err := g.generate(filepath.Join(dir, "p")); err != nil {
+		t.Fatalf("g.generate(%q) failed with %v; want success", filepath.Join(dir, "p"), err)
+	}
+	f := emitted["p/BUILD"]
+	if f == nil {
+		t.Fatalf("p/BUILD is not emitted; emitted = %v", emitted)
+	}
+	want := canonicalBuild(t, `
+package(default_visibility = ["//visibility:public"])
+
+licenses(["notice"])
+
+go_library(
+    name = "p",
+    srcs = ["p.go"],
+    importpath = "example.com/repo/p",
+    visibility = ["//visibility:public"],
+    deps = ["//third_party/ext"],
+)
+`)
+	if got := string(bzl.Format(f)); got != want {
+		t.Errorf("p/BUILD = %s; want %s", got, want)
+	}
+}
+
+func TestLoadConfigWithFlags(t *testing.T) {
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		configFile: `{
+  "go_prefix": "example.com/file",
+  "mode": "fix",
+  "flat": true,
+  "excludes": ["file"],
+  "known_imports": {"example.com/file": "//file"},
+  "licenses": ["notice"]
+}`,
+	})
+
+	defer setFlags(t, map[string]string{
+		"go_prefix":    "example.com/flag",
+		"flat":         "false",
+		"exclude":      "flag",
+		"known_import": "example.com/flag=//flag",
+		"licenses":     "restricted,reciprocal",
+	})()
+	c, err := loadConfig(dir)
+	if err != nil {
+		t.Fatalf("loadConfig(%q) failed with %v; want success", dir, err)
+	}
+	want := &config{
+		GoPrefix:      "example.com/flag",
+		Mode:          "fix",
+		Excludes:      []string{"flag"},
+		KnownImports:  map[string]string{"example.com/flag": "//flag"},
+		PureCondition: defaultPureCondition,
+		Licenses:      []string{"restricted", "reciprocal"},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("loadConfig(%q) = %#v; want %#v", dir, c, want)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// findCycles returns import cycles among targets in "gr".
+// A go_test rule and the library it embeds are compiled as a single package,
+// so a cycle through either of them is a cycle through both. Each cycle is a
+// list of edges in "gr", and one of the shortest cycles is returned for each
+// set of strongly connected targets.
+func findCycles(gr *depGraph) [][]graphEdge {
+	embedded := make(map[string]string)
+	for _, n := range gr.Nodes {
+		if n.Library != "" {
+			embedded[n.Label] = n.Library
+		}
+	}
+	canon := func(l string) string {
+		if lib, ok := embedded[l]; ok {
+			return lib
+		}
+		return l
+	}
+
+	// adj maps a target to its dependencies in the current repository, and
+	// via maps a pair of them to an edge which connects them. The edge has
+	// the sources of all the edges collapsed into the pair.
+	type pair struct{ from, to string }
+	adj := make(map[string][]string)
+	via := make(map[pair]graphEdge)
+	for _, e := range gr.Edges {
+		p := pair{from: canon(e.From), to: canon(e.To)}
+		if p.from == p.to || !strings.HasPrefix(p.to, "//") {
+			continue
+		}
+		if v, ok := via[p]; ok {
+			v.Sources = mergeSources(v.Sources, e.Sources)
+			via[p] = v
+			continue
+		}
+		e.Sources = mergeSources(nil, e.Sources)
+		via[p] = e
+		adj[p.from] = append(adj[p.from], p.to)
+	}
+
+	var cycles [][]graphEdge
+	for _, scc := range stronglyConnected(adj) {
+		in := make(map[string]bool)
+		for _, n := range scc {
+			in[n] = true
+		}
+
+		// Searches the shortest path from "start" back to itself in the
+		// component in breadth-first order.
+		start := scc[0]
+		prev := make(map[string]string)
+		queue := []string{start}
+		for len(queue) > 0 && prev[start] == "" {
+			n := queue[0]
+			queue = queue[1:]
+			for _, to := range adj[n] {
+				if _, ok := prev[to]; ok || !in[to] {
+					continue
+				}
+				prev[to] = n
+				queue = append(queue, to)
+			}
+		}
+
+		var cycle []graphEdge
+		for n := start; ; {
+			p := pair{from: prev[n], to: n}
+			cycle = append([]graphEdge{via[p]}, cycle...)
+			if n = p.from; n == start {
+				break
+			}
+		}
+		cycles = append(cycles, cycle)
+	}
+	return cycles
+}
+
+// mergeSources returns the sorted union of "x" and "y" without modifying
+// them.
+func mergeSources(x, y []string) []string {
+	seen := make(map[string]bool)
+	var sources []string
+	for _, src := range append(append([]string(nil), x...), y...) {
+		if !seen[src] {
+			seen[src] = true
+			sources = append(sources, src)
+		}
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// stronglyConnected returns strongly connected components with more than one
+// node in the graph "adj". Nodes in each component are sorted, and the
+// components are sorted by their first nodes.
+func stronglyConnected(adj map[string][]string) [][]string {
+	var nodes []string
+	for n := range adj {
+		nodes = append(nodes, n)
+	}
+	sort.Strings(nodes)
+
+	var (
+		index   = make(map[string]int)
+		lowlink = make(map[string]int)
+		onStack = make(map[string]bool)
+		stack   []string
+		sccs    [][]string
+		visit   func(n string)
+	)
+	// Tarjan's algorithm.
+	visit = func(n string) {
+		index[n] = len(index)
+		lowlink[n] = index[n]
+		stack = append(stack, n)
+		onStack[n] = true
+		for _, to := range adj[n] {
+			if _, ok := index[to]; !ok {
+				visit(to)
+				if lowlink[to] < lowlink[n] {
+					lowlink[n] = lowlink[to]
+				}
+			} else if onStack[to] && index[to] < lowlink[n] {
+				lowlink[n] = index[to]
+			}
+		}
+		if lowlink[n] != index[n] {
+			return
+		}
+		var scc []string
+		for {
+			m := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[m] = false
+			scc = append(scc, m)
+			if m == n {
+				break
+			}
+		}
+		if len(scc) > 1 {
+			sort.Strings(scc)
+			sccs = append(sccs, scc)
+		}
+	}
+	for _, n := range nodes {
+		if _, ok := index[n]; !ok {
+			visit(n)
+		}
+	}
+	sort.Slice(sccs, func(i, j int) bool { return sccs[i][0] < sccs[j][0] })
+	return sccs
+}
+
+// writeCycles writes "cycles" into "w" with the imports which cause them.
+func writeCycles(w io.Writer, cycles [][]graphEdge) error {
+	for _, cycle := range cycles {
+		labels := []string{cycle[0].From}
+		for _, e := range cycle {
+			labels = append(labels, e.To)
+		}
+		if _, err := fmt.Fprintf(w, "import cycle: %s\n", strings.Join(labels, " -> ")); err != nil {
+			return err
+		}
+		for _, e := range cycle {
+			sources := ""
+			if len(e.Sources) > 0 {
+				sources = fmt.Sprintf(" (%s)", strings.Join(e.Sources, ", "))
+			}
+			if _, err := fmt.Fprintf(w, "\t%s -> %s%s\n", e.From, e.To, sources); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// cycles writes import cycles among targets generated for "dirs" into "w".
+// It returns an error if any cycle is found.
+func (g *gen) cycles(w io.Writer, dirs []string) error {
+	gr, err := g.depGraph(g.defaultRoots(dirs))
+	if err != nil {
+		return err
+	}
+	cycles := findCycles(gr)
+	if err := writeCycles(w, cycles); err != nil {
+		return err
+	}
+	if len(cycles) > 0 {
+		return fmt.Errorf("found %d import cycle(s)", len(cycles))
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindCycles(t *testing.T) {
+	for _, spec := range []struct {
+		name string
+		gr   depGraph
+		want [][]string
+	}{
+		{
+			name: "no cycle",
+			gr: depGraph{
+				Nodes: []graphNode{
+					{Label: "//a:go_default_library", Kind: "go_library", Dir: "a"},
+					{Label: "//b:go_default_library", Kind: "go_library", Dir: "b"},
+					{Label: "@com_example_x//:go_default_library", Kind: "external"},
+				},
+				Edges: []graphEdge{
+					{From: "//a:go_default_library", To: "//b:go_default_library"},
+					{From: "//b:go_default_library", To: "@com_example_x//:go_default_library"},
+				},
+			},
+		},
+		{
+			name: "two nodes",
+			gr: depGraph{
+				Nodes: []graphNode{
+					{Label: "//a:go_default_library", Kind: "go_library", Dir: "a"},
+					{Label: "//b:go_default_library", Kind: "go_library", Dir: "b"},
+				},
+				Edges: []graphEdge{
+					{From: "//a:go_default_library", To: "//b:go_default_library", Sources: []string{"a/a.go:3"}},
+					{From: "//b:go_default_library", To: "//a:go_default_library", Sources: []string{"b/b.go:3"}},
+				},
+			},
+			want: [][]string{
+				{"//a:go_default_library -> //b:go_default_library", "//b:go_default_library -> //a:go_default_library"},
+			},
+		},
+		{
+			name: "embedded library",
+			gr: depGraph{
+				Nodes: []graphNode{
+					{Label: "//a:go_default_library", Kind: "go_library", Dir: "a"},
+					{Label: "//a:go_default_test", Kind: "go_test", Dir: "a", Library: "//a:go_default_library"},
+					{Label: "//b:go_default_library", Kind: "go_library", Dir: "b"},
+				},
+				Edges: []graphEdge{
+					{From: "//a:go_default_test", To: "//a:go_default_library", Test: true},
+					{From: "//a:go_default_test", To: "//b:go_default_library", Test: true, Sources: []string{"a/a_test.go:3"}},
+					{From: "//b:go_default_library", To: "//a:go_default_library", Sources: []string{"b/b.go:3"}},
+				},
+			},
+			want: [][]string{
+				{"//a:go_default_test -> //b:go_default_library", "//b:go_default_library -> //a:go_default_library"},
+			},
+		},
+		{
+			name: "external test",
+			gr: depGraph{
+				Nodes: []graphNode{
+					{Label: "//a:go_default_library", Kind: "go_library", Dir: "a"},
+					{Label: "//a:go_default_xtest", Kind: "go_test", Dir: "a"},
+					{Label: "//b:go_default_library", Kind: "go_library", Dir: "b"},
+				},
+				Edges: []graphEdge{
+					{From: "//a:go_default_xtest", To: "//a:go_default_library", Test: true, Sources: []string{"a/a_test.go:3"}},
+					{From: "//a:go_default_xtest", To: "//b:go_default_library", Test: true, Sources: []string{"a/a_test.go:4"}},
+					{From: "//b:go_default_library", To: "//a:go_default_library", Sources: []string{"b/b.go:3"}},
+				},
+			},
+		},
+	} {
+		var got [][]string
+		for _, cycle := range findCycles(&spec.gr) {
+			var edges []string
+			for _, e := range cycle {
+				edges = append(edges, e.From+" -> "+e.To)
+			}
+			got = append(got, edges)
+		}
+		if !reflect.DeepEqual(got, spec.want) {
+			t.Errorf("%s: findCycles(%#v) = %q; want %q", spec.name, spec.gr, got, spec.want)
+		}
+	}
+}
+
+func TestStronglyConnected(t *testing.T) {
+	adj := map[string][]string{
+		"a": {"b"},
+		"b": {"c", "d"},
+		"c": {"a"},
+		"d": {"e"},
+		"e": {"f"},
+		"f": {"e"},
+		"g": {"a"},
+	}
+	want := [][]string{{"a", "b", "c"}, {"e", "f"}}
+	if got := stronglyConnected(adj); !reflect.DeepEqual(got, want) {
+		t.Errorf("stronglyConnected(%v) = %q; want %q", adj, got, want)
+	}
+}
+
+func TestFindCyclesMergesSources(t *testing.T) {
+	gr := depGraph{
+		Nodes: []graphNode{
+			{Label: "//a:go_default_library", Kind: "go_library", Dir: "a"},
+			{Label: "//a:go_default_test", Kind: "go_test", Dir: "a", Library: "//a:go_default_library"},
+			{Label: "//b:go_default_library", Kind: "go_library", Dir: "b"},
+		},
+		Edges: []graphEdge{
+			{From: "//a:go_default_library", To: "//b:go_default_library", Sources: []string{"a/a.go:3"}},
+			{From: "//a:go_default_test", To: "//a:go_default_library", Test: true},
+			{From: "//a:go_default_test", To: "//b:go_default_library", Test: true, Sources: []string{"a/a_test.go:3"}},
+			{From: "//b:go_default_library", To: "//a:go_default_library", Sources: []string{"b/b.go:3"}},
+		},
+	}
+	cycles := findCycles(&gr)
+	if len(cycles) != 1 {
+		t.Fatalf("findCycles(%#v) = %v; want 1 cycle", gr, cycles)
+	}
+	var got [][]string
+	for _, e := range cycles[0] {
+		got = append(got, e.Sources)
+	}
+	want := [][]string{{"a/a.go:3", "a/a_test.go:3"}, {"b/b.go:3"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sources of findCycles(%#v) = %q; want %q", gr, got, want)
+	}
+}
@@ -8,12 +8,13 @@ import (
 	"github.com/bazelbuild/buildifier/differ"
 )
 
-func diffFile(fname string, rules []bzl.Expr) (err error) {
-	buildfile, err := reconcile(fname, rules)
-	if err != nil {
-		return err
+// diffFile shows the difference between "fname" and "buildfile". A nil
+// "buildfile" means removal of "fname".
+func diffFile(fname string, buildfile *bzl.File) (err error) {
+	if buildfile == nil {
+		differ.Find().Show(fname, os.DevNull)
+		return nil
 	}
-
 	f, err := ioutil.TempFile("", "BUILD")
 	if err != nil {
 		return err
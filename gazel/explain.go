@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+	"github.com/yugui/gazel/generator"
+)
+
+// explain writes into "w" why rules generated for "target" have their
+// sources and dependencies.
+// "target" is a directory of a Go package, or a label of a generated rule.
+// A label without a name means all the rules in the Bazel package.
+func (g *gen) explain(w io.Writer, target string) error {
+	root, want := target, ""
+	if strings.HasPrefix(target, "//") {
+		pkg := strings.TrimPrefix(target, "//")
+		if i := strings.Index(pkg, ":"); i >= 0 {
+			pkg, want = pkg[:i], target
+		}
+		root = filepath.Join(g.base, filepath.FromSlash(pkg))
+		if g.flat {
+			root = filepath.Join(g.base, "...")
+		}
+	}
+
+	var found bool
+	err := g.walk(root, func(rel string, pkg *build.Package, c *generator.Config) error {
+		rs, err := g.g.Generate(rel, pkg, c)
+		if err != nil {
+			return err
+		}
+		e, err := g.g.Explain(rel, pkg, c)
+		if err != nil {
+			return err
+		}
+		for _, r := range rs {
+			l, _ := absLabel(":"+r.Name(), g.bazelPackage(rel))
+			if want != "" && l != want {
+				continue
+			}
+			found = true
+			if err := g.writeExplanation(w, l, rel, r, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no rules are generated for %s", target)
+	}
+	return nil
+}
+
+// writeExplanation writes the explanation "e" of the rule "r" labeled "l"
+// generated for the Go package at "rel" into "w".
+func (g *gen) writeExplanation(w io.Writer, l, rel string, r *bzl.Rule, e *generator.Explanation) error {
+	var lines []string
+	printf := func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+	conditions := func(conds []string) string {
+		if len(conds) == 0 {
+			return ""
+		}
+		return fmt.Sprintf(" [%s]", strings.Join(conds, ", "))
+	}
+
+	printf("%s (%s)", l, r.Kind())
+	srcs := make(map[string]bool)
+	if list := uniqueStrings(stringsIn(r.Attr("srcs"))); len(list) > 0 {
+		printf("\tsrcs:")
+		for _, src := range list {
+			srcs[path.Base(src)] = true
+			var s generator.SourceExplanation
+			for _, x := range e.Srcs {
+				if x.File == path.Base(src) {
+					s = x
+				}
+			}
+			constraint := ""
+			if s.Constraint != "" {
+				constraint = ": " + s.Constraint
+			}
+			printf("\t\t%s%s%s", src, constraint, conditions(s.Conditions))
+		}
+	}
+	if library := r.AttrString("library"); library != "" {
+		printf("\tlibrary: %s (embedded)", library)
+	}
+	if deps := uniqueStrings(stringsIn(r.Attr("deps"))); len(deps) > 0 {
+		printf("\tdeps:")
+		for _, dep := range deps {
+			printf("\t\t%s", dep)
+			for _, d := range e.Deps {
+				if g.graphLabel(d.Label, rel) != g.graphLabel(dep, rel) {
+					continue
+				}
+				var pos []string
+				for _, p := range d.Positions {
+					if srcs[filepath.Base(p.Filename)] {
+						pos = append(pos, g.position(p))
+					}
+				}
+				if len(pos) == 0 {
+					continue
+				}
+				printf("\t\t\t%q by %s resolver%s: %s", d.Importpath, d.Resolver, conditions(d.Conditions), strings.Join(pos, ", "))
+			}
+		}
+	}
+
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
+
+// uniqueStrings returns "values" without duplicates in the original order.
+func uniqueStrings(values []string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -7,12 +7,12 @@ import (
 	bzl "github.com/bazelbuild/buildifier/core"
 )
 
-func fixFile(fname string, rules []bzl.Expr) (err error) {
-	buildfile, err := reconcile(fname, rules)
-	if err != nil {
-		return err
+// fixFile writes "buildfile" into "fname". It removes "fname" if
+// "buildfile" is nil.
+func fixFile(fname string, buildfile *bzl.File) (err error) {
+	if buildfile == nil {
+		return os.Remove(fname)
 	}
-
 	f, err := ioutil.TempFile("", "BUILD")
 	if err != nil {
 		return err
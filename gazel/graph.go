@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"go/token"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+	"github.com/yugui/gazel/generator"
+)
+
+// depGraph is a dependency graph among targets which gazel generates.
+type depGraph struct {
+	Nodes []graphNode `json:"nodes"`
+	Edges []graphEdge `json:"edges"`
+}
+
+// graphNode is a target in depGraph.
+type graphNode struct {
+	// Label is the label of the target in the form of "//pkg:name", or
+	// the label of an external target as generated.
+	Label string `json:"label"`
+	// Kind is the kind of the rule, "external" for external targets, or
+	// "unknown" for targets in the current repository which gazel does not
+	// generate, e.g. hand-written ones.
+	Kind string `json:"kind"`
+	// Dir is the relative slash-delimited path from the base dir to the
+	// Go package of the target. It is empty for external and unknown targets.
+	Dir string `json:"dir,omitempty"`
+	// Library is the label of the library which the target embeds, if any.
+	Library string `json:"library,omitempty"`
+}
+
+// graphEdge is a dependency in depGraph.
+type graphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Test is true if the dependency is of a go_test rule.
+	Test bool `json:"test,omitempty"`
+	// Sources is the list of positions of the imports which the dependency
+	// comes from, in the form of "file:line" relative to the base dir.
+	// It is empty for dependencies on embedded libraries.
+	Sources []string `json:"sources,omitempty"`
+}
+
+// depGraph generates rules for the Go packages specified by "roots", and
+// returns the dependency graph among them.
+func (g *gen) depGraph(roots []string) (*depGraph, error) {
+	nodes := make(map[string]graphNode)
+	type edgeKey struct {
+		from, to string
+		test     bool
+	}
+	edges := make(map[edgeKey]*graphEdge)
+	for _, root := range roots {
+		err := g.walk(root, func(rel string, pkg *build.Package, c *generator.Config) error {
+			rs, err := g.g.Generate(rel, pkg, c)
+			if err != nil {
+				return err
+			}
+			for _, r := range rs {
+				from, _ := absLabel(":"+r.Name(), g.bazelPackage(rel))
+				n := graphNode{Label: from, Kind: r.Kind(), Dir: rel}
+				if library := r.AttrString("library"); library != "" {
+					n.Library = g.graphLabel(library, rel)
+				}
+				nodes[from] = n
+				test := r.Kind() == "go_test"
+				sources, err := g.importSources(rel, pkg, r)
+				if err != nil {
+					return err
+				}
+				deps := stringsIn(r.Attr("deps"))
+				if library := r.AttrString("library"); library != "" {
+					deps = append(deps, library)
+				}
+				for _, dep := range deps {
+					to := g.graphLabel(dep, rel)
+					if _, ok := nodes[to]; !ok && !strings.HasPrefix(to, "//") {
+						nodes[to] = graphNode{Label: to, Kind: "external"}
+					}
+					key := edgeKey{from: from, to: to, test: test}
+					if edges[key] == nil {
+						edges[key] = &graphEdge{From: from, To: to, Test: test}
+					}
+					edges[key].Sources = append(edges[key].Sources, sources[to]...)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Dependencies on targets which are not generated keep the graph closed.
+	for key := range edges {
+		if _, ok := nodes[key.to]; !ok {
+			nodes[key.to] = graphNode{Label: key.to, Kind: "unknown"}
+		}
+	}
+
+	gr := new(depGraph)
+	for _, n := range nodes {
+		gr.Nodes = append(gr.Nodes, n)
+	}
+	sort.Slice(gr.Nodes, func(i, j int) bool { return gr.Nodes[i].Label < gr.Nodes[j].Label })
+	for _, e := range edges {
+		sort.Strings(e.Sources)
+		gr.Edges = append(gr.Edges, *e)
+	}
+	sort.Slice(gr.Edges, func(i, j int) bool {
+		a, b := gr.Edges[i], gr.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return !a.Test && b.Test
+	})
+	return gr, nil
+}
+
+// graphLabel returns the label of "dep" referenced from the Go package at
+// "rel" in depGraph.
+func (g *gen) graphLabel(dep, rel string) string {
+	if l, ok := absLabel(dep, g.bazelPackage(rel)); ok {
+		return l
+	}
+	return dep
+}
+
+// importSources maps labels of dependencies of the rule "r" generated for
+// the Go package "pkg" at "rel" into positions of the imports in "pkg" which
+// they come from.
+func (g *gen) importSources(rel string, pkg *build.Package, r *bzl.Rule) (map[string][]string, error) {
+	var pos map[string][]token.Position
+	switch r.Kind() {
+	case "go_library", "go_binary":
+		pos = pkg.ImportPos
+	case "go_test":
+		// A go_test rule may have both internal and external test files.
+		srcs := make(map[string]bool)
+		for _, src := range stringsIn(r.Attr("srcs")) {
+			srcs[path.Base(src)] = true
+		}
+		pos = make(map[string][]token.Position)
+		for _, t := range []struct {
+			files []string
+			pos   map[string][]token.Position
+		}{
+			{files: pkg.TestGoFiles, pos: pkg.TestImportPos},
+			{files: pkg.XTestGoFiles, pos: pkg.XTestImportPos},
+		} {
+			for _, f := range t.files {
+				if srcs[f] {
+					for imp, ps := range t.pos {
+						pos[imp] = append(pos[imp], ps...)
+					}
+					break
+				}
+			}
+		}
+	default:
+		return nil, nil
+	}
+
+	sources := make(map[string][]string)
+	for imp, ps := range pos {
+		dep, err := g.g.Dependency(rel, imp)
+		if err != nil {
+			return nil, err
+		}
+		if dep == "" {
+			continue
+		}
+		l := g.graphLabel(dep, rel)
+		for _, p := range ps {
+			sources[l] = append(sources[l], g.position(p))
+		}
+	}
+	return sources, nil
+}
+
+// position formats "p" in the form of "file:line" relative to the base dir.
+func (g *gen) position(p token.Position) string {
+	fname, err := filepath.Rel(g.base, p.Filename)
+	if err != nil {
+		fname = p.Filename
+	}
+	return fmt.Sprintf("%s:%d", filepath.ToSlash(fname), p.Line)
+}
+
+// graph writes the dependency graph among targets generated for "dirs" into
+// "w" in "format", "dot" or "json".
+func (g *gen) graph(w io.Writer, dirs []string, format string) error {
+	gr, err := g.depGraph(g.defaultRoots(dirs))
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "dot":
+		return writeDOT(w, gr)
+	case "json":
+		return writeJSON(w, gr)
+	default:
+		return fmt.Errorf("unrecognized graph format %q; want dot or json", format)
+	}
+}
+
+// bazelPackage returns the Bazel package which has rules for the Go package
+// at "rel".
+func (g *gen) bazelPackage(rel string) string {
+	if g.flat {
+		return ""
+	}
+	return rel
+}
+
+// writeDOT writes "gr" into "w" in the DOT language of Graphviz.
+// Dependencies of tests are dashed.
+func writeDOT(w io.Writer, gr *depGraph) error {
+	if _, err := fmt.Fprintln(w, "digraph deps {"); err != nil {
+		return err
+	}
+	for _, n := range gr.Nodes {
+		if _, err := fmt.Fprintf(w, "\t%q [kind=%q];\n", n.Label, n.Kind); err != nil {
+			return err
+		}
+	}
+	for _, e := range gr.Edges {
+		style := ""
+		if e.Test {
+			style = " [style=dashed]"
+		}
+		if _, err := fmt.Fprintf(w, "\t%q -> %q%s;\n", e.From, e.To, style); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// writeJSON writes "gr" into "w" in JSON.
+func writeJSON(w io.Writer, gr *depGraph) error {
+	buf, err := json.MarshalIndent(gr, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", buf)
+	return err
+}
+
+// defaultRoots returns "dirs", or the whole base dir if "dirs" is empty.
+func (g *gen) defaultRoots(dirs []string) []string {
+	if len(dirs) > 0 {
+		return dirs
+	}
+	return []string{filepath.Join(g.base, "...")}
+}
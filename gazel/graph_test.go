@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDepGraph(t *testing.T) {
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"a/a.go":      "package a\n\nimport (\n\t_ \"example.com/repo/b\"\n\t_ \"example.com/repo/hand\"\n)\n",
+		"a/a_test.go": "package a\n\nimport _ \"github.com/foo/bar\"\n",
+		"b/b.go":      "package b\n",
+		"hand/BUILD":  "go_library(name = \"go_default_library\")\n",
+	})
+
+	c := &config{GoPrefix: "example.com/repo"}
+	g, _ := newTestGen(t, dir, c)
+	roots := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}
+	gr, err := g.depGraph(roots)
+	if err != nil {
+		t.Fatalf("g.depGraph(%q) failed with %v; want success", roots, err)
+	}
+
+	want := &depGraph{
+		Nodes: []graphNode{
+			{Label: "//a:go_default_library", Kind: "go_library", Dir: "a"},
+			{Label: "//a:go_default_test", Kind: "go_test", Dir: "a", Library: "//a:go_default_library"},
+			{Label: "//b:go_default_library", Kind: "go_library", Dir: "b"},
+			{Label: "//hand:go_default_library", Kind: "unknown"},
+			{Label: "@com_github_foo_bar//:go_default_library", Kind: "external"},
+		},
+		Edges: []graphEdge{
+			{From: "//a:go_default_library", To: "//b:go_default_library", Sources: []string{"a/a.go:4"}},
+			{From: "//a:go_default_library", To: "//hand:go_default_library", Sources: []string{"a/a.go:5"}},
+			{From: "//a:go_default_test", To: "//a:go_default_library", Test: true},
+			{From: "//a:go_default_test", To: "@com_github_foo_bar//:go_default_library", Test: true, Sources: []string{"a/a_test.go:3"}},
+		},
+	}
+	if !reflect.DeepEqual(gr, want) {
+		t.Errorf("g.depGraph(%q) = %#v; want %#v", roots, gr, want)
+	}
+}
+
+// testGraph is a small dependency graph for tests of writers.
+var testGraph = &depGraph{
+	Nodes: []graphNode{
+		{Label: "//a:go_default_library", Kind: "go_library", Dir: "a"},
+		{Label: "//a:go_default_test", Kind: "go_test", Dir: "a", Library: "//a:go_default_library"},
+		{Label: "//b:go_default_library", Kind: "go_library", Dir: "b"},
+	},
+	Edges: []graphEdge{
+		{From: "//a:go_default_library", To: "//b:go_default_library", Sources: []string{"a/a.go:3"}},
+		{From: "//a:go_default_test", To: "//a:go_default_library", Test: true},
+	},
+}
+
+func TestWriteDOT(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeDOT(&buf, testGraph); err != nil {
+		t.Fatalf("writeDOT(&buf, %#v) failed with %v; want success", testGraph, err)
+	}
+	want := `digraph deps {
+	"//a:go_default_library" [kind="go_library"];
+	"//a:go_default_test" [kind="go_test"];
+	"//b:go_default_library" [kind="go_library"];
+	"//a:go_default_library" -> "//b:go_default_library";
+	"//a:go_default_test" -> "//a:go_default_library" [style=dashed];
+}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("writeDOT(&buf, %#v) wrote %s; want %s", testGraph, got, want)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, testGraph); err != nil {
+		t.Fatalf("writeJSON(&buf, %#v) failed with %v; want success", testGraph, err)
+	}
+	want := `{
+  "nodes": [
+    {
+      "label": "//a:go_default_library",
+      "kind": "go_library",
+      "dir": "a"
+    },
+    {
+      "label": "//a:go_default_test",
+      "kind": "go_test",
+      "dir": "a",
+      "library": "//a:go_default_library"
+    },
+    {
+      "label": "//b:go_default_library",
+      "kind": "go_library",
+      "dir": "b"
+    }
+  ],
+  "edges": [
+    {
+      "from": "//a:go_default_library",
+      "to": "//b:go_default_library",
+      "sources": [
+        "a/a.go:3"
+      ]
+    },
+    {
+      "from": "//a:go_default_test",
+      "to": "//a:go_default_library",
+      "test": true
+    }
+  ]
+}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("writeJSON(&buf, %#v) wrote %s; want %s", testGraph, got, want)
+	}
+}
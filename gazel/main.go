@@ -6,92 +6,377 @@ import (
 	"flag"
 	"fmt"
 	"go/build"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 
 	bzl "github.com/bazelbuild/buildifier/core"
 	"github.com/yugui/gazel/generator"
 )
 
+// defaultPureCondition is the default value of -pure_condition.
+const defaultPureCondition = "@io_bazel_rules_go//go/config:pure"
+
+// platformConditionPrefix is the prefix of the default labels of the
+// config_settings selecting platforms given by -platform.
+const platformConditionPrefix = "@io_bazel_rules_go//go/platform:"
+
 var (
-	goPrefix = flag.String("go_prefix", "", "go_prefix of the target workspace")
-	baseDir  = flag.String("base_dir", "", "path to a directory which corresponds to go_prefix")
-	flat     = flag.Bool("flat", false, "creates a large single BUILD file in the top of repository instead of creating a BUILD file for each Go package")
-	mode     = flag.String("mode", "print", "print, fix or diff")
+	configPath        = flag.String("config", "", "path to the project configuration file in JSON. Defaults to "+configFile+" in the base dir if exists. Flags given explicitly override it")
+	goPrefix          = flag.String("go_prefix", "", "go_prefix of the target workspace. Defaults to the module path in go.mod in the base dir")
+	baseDir           = flag.String("base_dir", "", "path to a directory which corresponds to go_prefix")
+	flat              = flag.Bool("flat", false, "creates a large single BUILD file in the top of repository instead of creating a BUILD file for each Go package")
+	mode              = flag.String("mode", "print", "print, fix or diff")
+	goVersion         = flag.String("go_version", "", "release of Go to generate BUILD files for, e.g. 1.9. Defaults to the one of the local toolchain")
+	pureCond          = flag.String("pure_condition", defaultPureCondition, "label of the config_setting selecting pure Go builds without cgo. Sources and dependencies specific to pure builds are put behind select() on it. Empty to disable. Disabled by default with -platform")
+	defaultVisibility = flag.String("default_visibility", "", "comma-separated list of labels to set to default_visibility of package() in generated BUILD files")
+	licenses          = flag.String("licenses", "", "comma-separated list of license types to set to licenses() in generated BUILD files, e.g. notice")
+	graphFormat       = flag.String("graph_format", "dot", "output format of the graph command, dot or json")
+	minimalVis        = flag.Bool("minimal_visibility", false, "restricts visibility of each go_library to the packages whose Go rules depend on it, found by generating rules for the whole base dir. Rules not generated by gazel are not taken into account. Not available in flat mode")
+	naming            = flag.String("naming", "", "naming convention of rules, go_default_library or import")
+	buildTags         = flag.String("build_tags", "", "comma-separated list of additional build tags")
+	swigTool          = flag.String("swig_tool", "", "label of the SWIG binary which generated genrules run. Defaults to swig in PATH")
+	cdepsMap          = flag.String("cdeps_map", "", "path to a table mapping pkg-config names and #include paths of cgo packages into labels of C/C++ rules. Each line is either \"pkg-config NAME LABEL\" or \"include PREFIX LABEL\"")
+
+	repoRoots           stringsFlag
+	goVersionConditions stringsFlag
+	platforms           stringsFlag
+	excludes            stringsFlag
+	knownImports        stringsFlag
 )
 
+func init() {
+	flag.Var(&repoRoots, "repo_root", "regular expression matching importpaths in an external repository, whose first subexpression matches the root of the repository. Can be repeated")
+	flag.Var(&goVersionConditions, "go_version_condition", "VERSION=LABEL puts sources and dependencies specific to the release VERSION of Go behind select() on the config_setting LABEL. Can be repeated. The config_settings must be exclusive to each other and to -pure_condition")
+	flag.Var(&platforms, "platform", "GOOS_GOARCH[=LABEL] puts sources and dependencies specific to the platform behind select() on the config_setting LABEL, which defaults to "+platformConditionPrefix+"GOOS_GOARCH. Can be repeated. Not available with -go_version_condition or -pure_condition, whose config_settings are not exclusive to platforms")
+	flag.Var(&excludes, "exclude", "slash-delimited path of a file or directory relative to the base dir to ignore. Can be repeated")
+	flag.Var(&knownImports, "known_import", "IMPORTPATH=LABEL resolves the importpath into the label. Can be repeated")
+}
+
+// stringsFlag is a flag.Value which can be specified multiple times.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
 type gen struct {
 	base string
-	bctx build.Context
-	g    generator.Generator
-	emit func(fname string, rules []bzl.Expr) error
+	// goPrefix is the go_prefix of the base dir.
+	goPrefix string
+	bctx     build.Context
+	g        generator.Generator
+	emit     func(fname string, f *bzl.File) error
+	// configs maps directories under the base dir to their configurations.
+	configs map[string]*generator.Config
+
+	// defaultVisibility and licenses are the arguments to package() and
+	// licenses() in generated BUILD files. They are omitted if empty.
+	defaultVisibility, licenses []string
+	// users maps labels of go_library rules to Bazel packages which depend
+	// on them, if -minimal_visibility is enabled.
+	users map[string]map[string]bool
+	// generated maps Go packages to the rules which reverseDeps generated for
+	// them, so that they are not generated again.
+	generated map[string][]*bzl.Rule
+	// flat is true if rules for all the packages are in the base dir.
+	flat bool
+
+	// visited is a set of directories of Go packages which have already been
+	// processed.
+	visited map[string]bool
 }
 
-func newGen() (*gen, error) {
-	base, err := filepath.Abs(*baseDir)
+func newGen(base string, c *config) (*gen, error) {
+	base, err := filepath.Abs(base)
 	if err != nil {
 		return nil, err
 	}
 
+	bc, err := c.baseConfig(base)
+	if err != nil {
+		return nil, err
+	}
+	configs, err := generator.ScanConfigs(base, bc)
+	if err != nil {
+		return nil, err
+	}
+	nested, err := generator.FindModules(base, bc)
+	if err != nil {
+		return nil, err
+	}
+	required, err := requiredModules(base, nested)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := repoRootRules(c)
+	if err != nil {
+		return nil, err
+	}
+	opts := []generator.Option{
+		generator.WithConfigs(configs),
+		generator.WithModules(nested),
+		generator.WithExternalModules(required, generator.DefaultModCache()),
+		generator.WithRepoRootRules(rules),
+		generator.WithKnownImports(c.KnownImports),
+	}
+	std, err := stdPackages(c.GoVersion)
+	if err != nil {
+		return nil, err
+	}
+	if std != nil {
+		opts = append(opts, generator.WithStdPackages(std))
+	}
+	if c.CDepsMap != "" {
+		buf, err := ioutil.ReadFile(c.CDepsMap)
+		if err != nil {
+			return nil, err
+		}
+		m, err := generator.ParseCDepsMap(buf)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", c.CDepsMap, err)
+		}
+		opts = append(opts, generator.WithCDeps(m))
+	}
+
 	bctx := build.Default
 	// Ignore $GOPATH environment variable
 	bctx.GOPATH = ""
+	bctx.CgoEnabled = true
+	if c.GoVersion != "" {
+		if bctx.ReleaseTags, err = generator.ReleaseTags(c.GoVersion); err != nil {
+			return nil, err
+		}
+	}
+	variants, err := goVersionVariants(bctx, c.GoVersionConditions)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Platforms) > 0 {
+		if len(variants) > 0 || c.PureCondition != "" {
+			return nil, fmt.Errorf("-platform is not available with -go_version_condition or -pure_condition")
+		}
+		if variants, err = platformVariants(bctx, c.Platforms); err != nil {
+			return nil, err
+		}
+	}
+	if c.PureCondition != "" {
+		pure := bctx
+		pure.CgoEnabled = false
+		variants = append(variants, generator.Variant{
+			Condition: c.PureCondition,
+			Context:   pure,
+		})
+	}
+	opts = append(opts, generator.WithVariants(variants), generator.WithSwig(c.SwigTool, bctx.GOARCH))
 
 	m := generator.StructuredMode
-	if *flat {
+	if c.Flat {
 		m = generator.FlatMode
 	}
 
 	g := gen{
-		base: filepath.Clean(base),
-		bctx: bctx,
-		g:    generator.New(*goPrefix, m),
+		base:     filepath.Clean(base),
+		goPrefix: c.GoPrefix,
+		flat:     c.Flat,
+		bctx:     bctx,
+		g:        generator.New(c.GoPrefix, m, opts...),
+		emit:     emitter(c.Mode),
+		configs:  configs,
+		visited:  make(map[string]bool),
+
+		defaultVisibility: c.DefaultVisibility,
+		licenses:          c.Licenses,
 	}
-	switch *mode {
-	case "print":
-		g.emit = printFile
+	return &g, nil
+}
+
+// repoRootRules compiles the -repo_root rules in "c".
+func repoRootRules(c *config) ([]*regexp.Regexp, error) {
+	var rules []*regexp.Regexp
+	for _, r := range c.RepoRoots {
+		re, err := regexp.Compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("malformed -repo_root %q: %v", r, err)
+		}
+		rules = append(rules, re)
+	}
+	return rules, nil
+}
+
+// goVersionVariants returns variants of "bctx" specified by "conditions",
+// whose elements are in the form of VERSION=LABEL.
+func goVersionVariants(bctx build.Context, conditions []string) ([]generator.Variant, error) {
+	var variants []generator.Variant
+	for _, c := range conditions {
+		kv := strings.SplitN(c, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("malformed -go_version_condition %q; want VERSION=LABEL", c)
+		}
+		tags, err := generator.ReleaseTags(kv[0])
+		if err != nil {
+			return nil, err
+		}
+		ctx := bctx
+		ctx.ReleaseTags = tags
+		variants = append(variants, generator.Variant{
+			Condition: kv[1],
+			Context:   ctx,
+		})
+	}
+	return variants, nil
+}
+
+// platformVariants returns variants of "bctx" specified by "platforms",
+// whose elements are in the form of GOOS_GOARCH[=LABEL].
+func platformVariants(bctx build.Context, platforms []string) ([]generator.Variant, error) {
+	var variants []generator.Variant
+	for _, p := range platforms {
+		kv := strings.SplitN(p, "=", 2)
+		osArch := strings.SplitN(kv[0], "_", 2)
+		if len(osArch) != 2 || osArch[0] == "" || osArch[1] == "" {
+			return nil, fmt.Errorf("malformed -platform %q; want GOOS_GOARCH[=LABEL]", p)
+		}
+		cond := platformConditionPrefix + kv[0]
+		if len(kv) == 2 {
+			cond = kv[1]
+		}
+		ctx := bctx
+		ctx.GOOS, ctx.GOARCH = osArch[0], osArch[1]
+		variants = append(variants, generator.Variant{
+			Condition: cond,
+			Context:   ctx,
+		})
+	}
+	return variants, nil
+}
+
+// stdPackages returns the set of standard packages in the release "version"
+// of Go, or in the local toolchain if "version" is empty.
+// It returns nil if neither is available.
+func stdPackages(version string) (map[string]bool, error) {
+	if version != "" {
+		return generator.StdPackages(version)
+	}
+	goroot := build.Default.GOROOT
+	if goroot == "" {
+		return nil, nil
+	}
+	if _, err := os.Stat(filepath.Join(goroot, "src")); err != nil {
+		return nil, nil
+	}
+	return generator.StdPackagesInGOROOT(goroot)
+}
+
+// requiredModules returns module paths required by go.mod files in "base"
+// and its nested modules "nested".
+func requiredModules(base string, nested []generator.Module) ([]string, error) {
+	dirs := []string{base}
+	for _, m := range nested {
+		dirs = append(dirs, filepath.Join(base, filepath.FromSlash(m.Dir)))
+	}
+
+	var required []string
+	for _, dir := range dirs {
+		fname := filepath.Join(dir, "go.mod")
+		buf, err := ioutil.ReadFile(fname)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		mf, err := generator.ParseModFile(buf)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fname, err)
+		}
+		for _, r := range mf.Require {
+			required = append(required, r.Path)
+		}
+	}
+	return required, nil
+}
+
+// basePrefix returns the importpath of the base dir "base" specified by
+// "gazel:prefix" in its BUILD file or by its go.mod.
+func basePrefix(base string) (string, error) {
+	ds, err := generator.ReadDirectives(base)
+	if err != nil {
+		return "", err
+	}
+	for i := len(ds) - 1; i >= 0; i-- {
+		if ds[i].Key == "prefix" && ds[i].Value != "" {
+			return ds[i].Value, nil
+		}
+	}
+	return generator.ReadModulePath(base)
+}
+
+// emitter returns a function which outputs a file in the way specified by
+// "mode".
+func emitter(mode string) func(fname string, f *bzl.File) error {
+	switch mode {
 	case "fix":
-		g.emit = fixFile
+		return fixFile
 	case "diff":
-		g.emit = diffFile
+		return diffFile
+	default:
+		return printFile
 	}
-	return &g, nil
 }
 
-func (g gen) generate(root string) error {
-	drive := func(bctx build.Context, root string, f generator.WalkFunc) error {
-		pkg, err := bctx.ImportDir(root, build.ImportComment)
+// walk calls back "f" for each Go package specified by "root".
+// It traverses subpackages if "root" ends with "/...".
+// "f" receives a relative slash-delimited path from the base dir to the
+// package, and the configuration of the directory. Packages which have
+// already been visited or which have "gazel:ignore" are skipped.
+func (g *gen) walk(root string, f func(rel string, pkg *build.Package, c *generator.Config) error) error {
+	drive := func(bctx build.Context, root string, c *generator.Config, f generator.WalkFunc) error {
+		if c.Ignore {
+			return nil
+		}
+		cctx := c.Context(bctx)
+		pkg, err := cctx.ImportDir(root, build.ImportComment)
+		if _, ok := err.(*build.NoGoError); ok {
+			return nil
+		}
 		if err != nil {
 			return err
 		}
-		return f(pkg)
+		return f(pkg, c)
 	}
 	if filepath.Base(root) == "..." {
 		drive = generator.Walk
 		root = filepath.Dir(root)
 	}
 
-	root, err := filepath.Abs(root)
+	root, rel, err := g.dirOf(root)
 	if err != nil {
 		return err
 	}
-	root = filepath.Clean(root)
-	if root != g.base && !strings.HasPrefix(root, fmt.Sprintf("%s%c", g.base, filepath.Separator)) {
-		return fmt.Errorf("dir %s is not under the base dir %s", root, g.base)
+	c, ok := g.configs[rel]
+	if !ok {
+		// "root" is excluded by its parent but explicitly specified.
+		if c, err = generator.LoadConfig(root, nil); err != nil {
+			return err
+		}
 	}
 
-	var rules []bzl.Expr
-	if root == g.base {
-		rules = append(rules, &bzl.CallExpr{
-			X: &bzl.LiteralExpr{Token: "go_prefix"},
-			List: []bzl.Expr{
-				&bzl.StringExpr{Value: *goPrefix},
-			},
-		})
-	}
-	err = drive(g.bctx, root, func(pkg *build.Package) error {
+	return drive(g.bctx, root, c, func(pkg *build.Package, c *generator.Config) error {
+		if g.visited[pkg.Dir] {
+			return nil
+		}
+		g.visited[pkg.Dir] = true
+
 		rel, err := filepath.Rel(g.base, pkg.Dir)
 		if err != nil {
 			return err
@@ -99,39 +384,234 @@ func (g gen) generate(root string) error {
 		if rel == "." {
 			rel = ""
 		}
+		return f(filepath.ToSlash(rel), pkg, c)
+	})
+}
 
-		rs, err := g.g.Generate(filepath.ToSlash(rel), pkg)
+// dirOf returns the absolute path to the directory "dir" and the relative
+// slash-delimited path to it from the base dir.
+// It returns an error if "dir" is not under the base dir.
+func (g *gen) dirOf(dir string) (abs, rel string, err error) {
+	abs, err = filepath.Abs(dir)
+	if err != nil {
+		return "", "", err
+	}
+	abs = filepath.Clean(abs)
+	if abs != g.base && !strings.HasPrefix(abs, fmt.Sprintf("%s%c", g.base, filepath.Separator)) {
+		return "", "", fmt.Errorf("dir %s is not under the base dir %s", abs, g.base)
+	}
+	if rel, err = filepath.Rel(g.base, abs); err != nil {
+		return "", "", err
+	}
+	if rel == "." {
+		rel = ""
+	}
+	return abs, filepath.ToSlash(rel), nil
+}
+
+// generate generates a BUILD file for each Go package specified by "root",
+// and removes Go rules in directories there without Go packages.
+func (g *gen) generate(root string) error {
+	if err := g.walk(root, g.generatePackage); err != nil {
+		return err
+	}
+	if err := g.prune(root); err != nil {
+		return err
+	}
+
+	// The base dir always has go_prefix even without Go packages.
+	dir := root
+	if filepath.Base(dir) == "..." {
+		dir = filepath.Dir(dir)
+	}
+	abs, rel, err := g.dirOf(dir)
+	if err != nil {
+		return err
+	}
+	if rel != "" || g.visited[abs] {
+		return nil
+	}
+	g.visited[abs] = true
+	return g.emitRules(filepath.Join(abs, "BUILD"), g.statements(""))
+}
+
+// prune removes Go rules from BUILD files in the directories specified by
+// "root" which no longer have Go packages. It deletes BUILD files which
+// become empty. Directories with "gazel:ignore" are left as they are.
+func (g *gen) prune(root string) error {
+	recursive := filepath.Base(root) == "..."
+	if recursive {
+		root = filepath.Dir(root)
+	}
+	_, rel, err := g.dirOf(root)
+	if err != nil {
+		return err
+	}
+
+	var dirs []string
+	for d, c := range g.configs {
+		// The base dir is taken care of by generate.
+		if c.Ignore || d == "" {
+			continue
+		}
+		if d == rel || recursive && (rel == "" || strings.HasPrefix(d, rel+"/")) {
+			dirs = append(dirs, d)
+		}
+	}
+	sort.Strings(dirs)
+	for _, d := range dirs {
+		dir := filepath.Join(g.base, filepath.FromSlash(d))
+		if g.visited[dir] {
+			continue
+		}
+		fname := filepath.Join(dir, "BUILD")
+		f, empty, err := prune(fname)
 		if err != nil {
 			return err
 		}
-		for _, r := range rs {
-			rules = append(rules, r.Call)
+		if f == nil {
+			continue
+		}
+		if empty {
+			f = nil
+		}
+		if err := g.emit(fname, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generatePackage generates a BUILD file for the Go package "pkg" at "rel".
+func (g *gen) generatePackage(rel string, pkg *build.Package, c *generator.Config) error {
+	b := buildFile{rules: g.statements(rel)}
+	rs, ok := g.generated[rel]
+	if !ok {
+		var err error
+		if rs, err = g.g.Generate(rel, pkg, c); err != nil {
+			return err
 		}
+	}
+	if g.users != nil {
+		g.restrictVisibility(rel, rs)
+	}
+	if err := b.add(rel, rs); err != nil {
+		return err
+	}
+	return g.emitRules(filepath.Join(pkg.Dir, "BUILD"), b.rules)
+}
 
-		if !*flat {
-			if err := g.emit(filepath.Join(pkg.Dir, "BUILD"), rules); err != nil {
+// generateFlat generates a single BUILD file in the base dir for all the
+// Go packages specified by "roots".
+func (g *gen) generateFlat(roots []string) error {
+	b := buildFile{rules: g.statements("")}
+	for _, root := range roots {
+		err := g.walk(root, func(rel string, pkg *build.Package, c *generator.Config) error {
+			rs, err := g.g.Generate(rel, pkg, c)
+			if err != nil {
 				return err
 			}
-			rules = nil
+			return b.add(rel, rs)
+		})
+		if err != nil {
+			return err
 		}
-		return nil
-	})
+	}
+	return g.emitRules(filepath.Join(g.base, "BUILD"), b.rules)
+}
+
+// emitRules reconciles the BUILD file "fname" with "rules" and outputs it.
+func (g *gen) emitRules(fname string, rules []bzl.Expr) error {
+	f, err := reconcile(fname, rules)
 	if err != nil {
 		return err
 	}
+	return g.emit(fname, f)
+}
+
+// buildFile is a list of rules to be emitted into a BUILD file.
+type buildFile struct {
+	rules []bzl.Expr
+	// owners maps names of rules in "rules" to the packages which generated them.
+	owners map[string]string
+}
 
-	if *flat {
-		return g.emit(filepath.Join(root, "BUILD"), rules)
+// add appends rules "rs" generated for the Go package at "rel" into "b".
+// It returns an error if any of "rs" has the same name as an existing rule.
+func (b *buildFile) add(rel string, rs []*bzl.Rule) error {
+	if b.owners == nil {
+		b.owners = make(map[string]string)
+	}
+	for _, r := range rs {
+		name := r.AttrString("name")
+		if other, ok := b.owners[name]; ok {
+			return fmt.Errorf("packages %q and %q generate rules with the same name %q", other, rel, name)
+		}
+		b.owners[name] = rel
+		b.rules = append(b.rules, r.Call)
 	}
 	return nil
 }
 
-func run(dirs []string) error {
-	g, err := newGen()
+// statements returns top-level statements which gazel manages in the BUILD
+// file in the directory "rel": package() and licenses() if configured, and
+// go_prefix() in the base dir.
+func (g *gen) statements(rel string) []bzl.Expr {
+	var stmts []bzl.Expr
+	if len(g.defaultVisibility) > 0 {
+		stmts = append(stmts, &bzl.CallExpr{
+			X: &bzl.LiteralExpr{Token: "package"},
+			List: []bzl.Expr{
+				&bzl.BinaryExpr{
+					X:  &bzl.LiteralExpr{Token: "default_visibility"},
+					Op: "=",
+					Y:  stringList(g.defaultVisibility),
+				},
+			},
+		})
+	}
+	if len(g.licenses) > 0 {
+		stmts = append(stmts, &bzl.CallExpr{
+			X:    &bzl.LiteralExpr{Token: "licenses"},
+			List: []bzl.Expr{stringList(g.licenses)},
+		})
+	}
+	if rel == "" {
+		stmts = append(stmts, &bzl.CallExpr{
+			X: &bzl.LiteralExpr{Token: "go_prefix"},
+			List: []bzl.Expr{
+				&bzl.StringExpr{Value: g.goPrefix},
+			},
+		})
+	}
+	return stmts
+}
+
+func stringList(values []string) *bzl.ListExpr {
+	list := new(bzl.ListExpr)
+	for _, v := range values {
+		list.List = append(list.List, &bzl.StringExpr{Value: v})
+	}
+	return list
+}
+
+func run(base string, c *config, dirs []string) error {
+	g, err := newGen(base, c)
 	if err != nil {
 		return err
 	}
 
+	if c.Flat {
+		if c.MinimalVisibility {
+			return fmt.Errorf("-minimal_visibility is not available in flat mode")
+		}
+		return g.generateFlat(dirs)
+	}
+	if c.MinimalVisibility {
+		if g.users, err = g.reverseDeps(); err != nil {
+			return err
+		}
+	}
 	for _, d := range dirs {
 		if err := g.generate(d); err != nil {
 			return err
@@ -141,7 +621,12 @@ func run(dirs []string) error {
 }
 
 func usage() {
-	fmt.Fprintln(os.Stderr, `usage: gazel [flags...] [package-dirs...]
+	fmt.Fprint(os.Stderr, `usage: gazel [flags...] [package-dirs...]
+       gazel [flags...] update-repos [go.mod or lock-file]
+       gazel [flags...] graph [package-dirs...]
+       gazel [flags...] cycles [package-dirs...]
+       gazel [flags...] explain <package-dir or label>
+       gazel [flags...] orphans
 
 Gazel is a BUILD file generator for Go projects.
 
@@ -153,6 +638,56 @@ notice.
 It takes a list of paths to Go package directories.
 It recursively traverses its subpackages if the directory path ends with "/...".
 All the directories must be under the directory specified in -base_dir.
+Unless -go_prefix is given, the module path in go.mod in the base dir is used
+as go_prefix. Subdirectories with their own go.mod are treated as nested
+modules.
+In flat mode, rules for all the packages are merged into a single BUILD file
+in the base dir.
+
+BUILD files can have directive comments "# gazel:<key> <value>", which apply to
+the directory and, unless noted, its subdirectories:
+  gazel:prefix IMPORTPATH  sets the importpath of the directory
+  gazel:ignore             does not generate rules for the directory only
+  gazel:exclude NAME       hides a file or subdirectory in the directory only
+  gazel:build_tags A,B     sets additional build tags
+  gazel:naming CONVENTION  names rules "go_default_library" or after "import"
+                           paths
+
+Flags can also be given in the project configuration file, .gazel.json in the
+base dir, e.g. {"go_prefix": "example.com/repo", "build_tags": ["foo"],
+"excludes": ["testdata"], "known_imports": {"example.com/x": "//x:lib"}}.
+Its keys are the names of the flags, pluralized for repeatable ones.
+Flags given on the command line override it.
+
+With the update-repos command, gazel reads go.mod and go.sum in the base dir
+and updates go_repository rules in the WORKSPACE file there instead.
+If go.mod does not exist, it reads a lock file of a legacy dependency
+management tool (Gopkg.lock, glide.lock, Godeps/Godeps.json or
+vendor/vendor.json) and pins the repositories to the recorded revisions.
+Existing go_repository rules are updated in place. New ones are loaded from
+@bazel_gazelle//:deps.bzl unless the WORKSPACE file already loads go_repository.
+
+With the graph command, gazel prints the dependency graph among the rules it
+would generate for the packages, or for all the packages in the base dir, in
+the format specified by -graph_format. Dependencies of go_test rules are marked
+as test dependencies, and edges in JSON have the positions of the imports which
+they come from. Dependencies which gazel does not generate are nodes of kind
+"external" or, if they are in the current repository, "unknown".
+
+With the cycles command, gazel reports import cycles among the rules it would
+generate, including ones which only exist through go_test rules embedding
+libraries, with the imports which cause them. It exits with an error if any
+cycle is found.
+
+With the explain command, gazel prints each source file of the rules it would
+generate for a package, or for a rule specified by a label, with the build
+constraints which select it, and each dependency with the imports which produce
+it and the resolver which produces the label.
+
+With the orphans command, gazel reports go_library rules it would generate in
+the base dir which no other Go package depends on, i.e. candidates for deletion,
+and dependencies on labels in the current repository which neither gazel
+generates nor BUILD files define.
 
 There are several modes of gazel.
 In print mode, gazel prints reconciled BUILD files to stdout.
@@ -168,24 +703,101 @@ func main() {
 	flag.Usage = usage
 	flag.Parse()
 
-	if *goPrefix == "" {
-		// TODO(yugui) Extract go_prefix from the top level BUILD file if exists
-		log.Fatal("-go_prefix is required")
+	if flag.NArg() > 0 && flag.Arg(0) == "update-repos" {
+		if flag.NArg() > 2 {
+			log.Fatal("update-repos takes at most one file")
+		}
+		base := *baseDir
+		if base == "" {
+			base = "."
+		}
+		c, err := loadConfig(base)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rules, err := repoRootRules(c)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := updateRepos(base, flag.Arg(1), rules, emitter(c.Mode)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.NArg() > 0 && (flag.Arg(0) == "graph" || flag.Arg(0) == "cycles" || flag.Arg(0) == "explain" || flag.Arg(0) == "orphans") {
+		base := *baseDir
+		if base == "" {
+			base = "."
+		}
+		c, err := loadGenConfig(base)
+		if err != nil {
+			log.Fatal(err)
+		}
+		g, err := newGen(base, c)
+		if err != nil {
+			log.Fatal(err)
+		}
+		args := flag.Args()[1:]
+		switch flag.Arg(0) {
+		case "graph":
+			err = g.graph(os.Stdout, args, *graphFormat)
+		case "cycles":
+			err = g.cycles(os.Stdout, args)
+		case "explain":
+			if len(args) != 1 {
+				usage()
+				os.Exit(1)
+			}
+			err = g.explain(os.Stdout, args[0])
+		case "orphans":
+			if len(args) != 0 {
+				usage()
+				os.Exit(1)
+			}
+			err = g.orphans(os.Stdout)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
-	if *baseDir == "" {
+
+	base := *baseDir
+	if base == "" {
 		if flag.NArg() != 1 {
 			log.Fatal("-base_dir is required")
 		}
-		*baseDir = flag.Arg(0)
-		if dir, base := filepath.Split(*baseDir); base == "..." {
-			*baseDir = dir
+		base = flag.Arg(0)
+		if dir, last := filepath.Split(base); last == "..." {
+			base = dir
 		}
 	}
-	if len(flag.Args()) > 1 && *flat {
-		log.Fatal("can have only one argument when -flat=true")
+	c, err := loadGenConfig(base)
+	if err != nil {
+		log.Fatal(err)
 	}
-
-	if err := run(flag.Args()); err != nil {
+	if err := run(base, c, flag.Args()); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// loadGenConfig is like loadConfig, but it also fills go_prefix from the
+// base dir "base" if not configured.
+func loadGenConfig(base string) (*config, error) {
+	c, err := loadConfig(base)
+	if err != nil {
+		return nil, err
+	}
+	if c.GoPrefix == "" {
+		p, err := basePrefix(base)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("-go_prefix is required unless the base dir has go.mod or gazel:prefix in BUILD")
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.GoPrefix = p
+	}
+	return c, nil
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+)
+
+func tempDir() (string, error) {
+	return ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "gazel_test")
+}
+
+// writeFiles creates files in "dir". "files" maps slash-delimited paths
+// relative to "dir" to their contents.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for p, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("os.MkdirAll(%q, 0700) failed with %v; want success", filepath.Dir(path), err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("ioutil.WriteFile(%q, %q, 0600) failed with %v; want success", path, content, err)
+		}
+	}
+}
+
+// newTestGen returns a gen for the base dir "dir", which records emitted
+// files into the returned map keyed by relative slash-delimited paths from
+// "dir". A nil file means removal.
+func newTestGen(t *testing.T, dir string, c *config) (*gen, map[string]*bzl.File) {
+	g, err := newGen(dir, c)
+	if err != nil {
+		t.Fatalf("newGen(%q, %#v) failed with %v; want success", dir, c, err)
+	}
+	emitted := make(map[string]*bzl.File)
+	g.emit = func(fname string, f *bzl.File) error {
+		rel, err := filepath.Rel(g.base, fname)
+		if err != nil {
+			return err
+		}
+		emitted[filepath.ToSlash(rel)] = f
+		return nil
+	}
+	return g, emitted
+}
+
+func TestGenerateWithBuildTags(t *testing.T) {
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		configFile:    `{"go_prefix": "example.com/repo", "build_tags": ["extra"]}`,
+		"p/p.go":      "package p\n",
+		"p/tagged.go": "// +build extra\n\npackage p\n",
+		"p/other.go":  "// +build other\n\npackage p\n",
+	})
+
+	c, err := loadConfig(dir)
+	if err != nil {
+		t.Fatalf("loadConfig(%q) failed with %v; want success", dir, err)
+	}
+	if got, want := c.BuildTags, []string{"extra"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("c.BuildTags = %q; want %q", got, want)
+	}
+	g, emitted := newTestGen(t, dir, c)
+	if err := g.generate(filepath.Join(dir, "p")); err != nil {
+		t.Fatalf("g.generate(%q) failed with %v; want success", filepath.Join(dir, "p"), err)
+	}
+	f := emitted["p/BUILD"]
+	if f == nil {
+		t.Fatalf("p/BUILD is not emitted; emitted = %v", emitted)
+	}
+	rs := f.Rules("go_library")
+	if len(rs) != 1 {
+		t.Fatalf("go_library rules in p/BUILD = %d; want 1", len(rs))
+	}
+	if got, want := rs[0].AttrStrings("srcs"), []string{"p.go", "tagged.go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("srcs = %q; want %q", got, want)
+	}
+}
+
+func TestGenerateWithPlatforms(t *testing.T) {
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"p/p.go":       "package p\n",
+		"p/p_plan9.go": "package p\n",
+	})
+
+	c := &config{GoPrefix: "example.com/repo", Platforms: []string{"plan9_386", "plan9_arm=//config:plan9_arm"}}
+	g, emitted := newTestGen(t, dir, c)
+	if err := g.generate(filepath.Join(dir, "p")); err != nil {
+		t.Fatalf("g.generate(%q) failed with %v; want success", filepath.Join(dir, "p"), err)
+	}
+	f := emitted["p/BUILD"]
+	if f == nil {
+		t.Fatalf("p/BUILD is not emitted; emitted = %v", emitted)
+	}
+	want := `["p.go"] + select({
+    "@io_bazel_rules_go//go/platform:plan9_386": ["p_plan9.go"],
+    "//config:plan9_arm": ["p_plan9.go"],
+    "//conditions:default": [],
+})`
+	r := f.Rules("go_library")[0]
+	if got := bzl.FormatString(r.Attr("srcs")); got != want {
+		t.Errorf("srcs = %s; want %s", got, want)
+	}
+
+	c.PureCondition = defaultPureCondition
+	if _, err := newGen(dir, c); err == nil {
+		t.Errorf("newGen(%q, %#v) succeeded; want failure", dir, c)
+	}
+}
+
+func TestLoadConfigPureCondition(t *testing.T) {
+	for _, spec := range []struct {
+		config string
+		want   string
+	}{
+		{
+			config: `{}`,
+			want:   defaultPureCondition,
+		},
+		{
+			config: `{"platforms": ["plan9_386"]}`,
+			want:   "",
+		},
+		{
+			config: `{"platforms": ["plan9_386"], "pure_condition": "//config:pure"}`,
+			want:   "//config:pure",
+		},
+		{
+			config: `{"pure_condition": ""}`,
+			want:   "",
+		},
+	} {
+		dir, err := tempDir()
+		if err != nil {
+			t.Fatalf("tempDir() failed with %v; want success", err)
+		}
+		defer os.RemoveAll(dir)
+		writeFiles(t, dir, map[string]string{configFile: spec.config})
+
+		c, err := loadConfig(dir)
+		if err != nil {
+			t.Errorf("loadConfig(%q) failed with %v; want success; config = %s", dir, err, spec.config)
+			continue
+		}
+		if got := c.PureCondition; got != spec.want {
+			t.Errorf("c.PureCondition = %q; want %q; config = %s", got, spec.want, spec.config)
+		}
+	}
+}
+
+// pruneTestFiles is a tree in which Go files have been removed from "gone",
+// "kept" and "ignored".
+var pruneTestFiles = map[string]string{
+	"p/p.go": "package p\n",
+	"gone/BUILD": `go_library(
+    name = "go_default_library",
+    srcs = ["gone.go"],
+)
+`,
+	"kept/BUILD": `filegroup(
+    name = "data",
+    srcs = ["data.txt"],
+)
+
+go_library(
+    name = "go_default_library",
+    srcs = ["kept.go"],
+)
+`,
+	"ignored/BUILD": `# gazel:ignore
+
+go_library(
+    name = "go_default_library",
+    srcs = ["ignored.go"],
+)
+`,
+}
+
+func TestPrune(t *testing.T) {
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, pruneTestFiles)
+
+	c := &config{GoPrefix: "example.com/repo", Mode: "fix"}
+	g, err := newGen(dir, c)
+	if err != nil {
+		t.Fatalf("newGen(%q, %#v) failed with %v; want success", dir, c, err)
+	}
+	root := filepath.Join(dir, "...")
+	if err := g.generate(root); err != nil {
+		t.Fatalf("g.generate(%q) failed with %v; want success", root, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "gone", "BUILD")); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%q) returned %v; want a not-exist error", filepath.Join(dir, "gone", "BUILD"), err)
+	}
+	for _, spec := range []struct {
+		fname, want string
+	}{
+		{
+			fname: "kept/BUILD",
+			want: `filegroup(
+    name = "data",
+    srcs = ["data.txt"],
+)
+`,
+		},
+		{
+			fname: "ignored/BUILD",
+			want:  pruneTestFiles["ignored/BUILD"],
+		},
+	} {
+		fname := filepath.Join(dir, filepath.FromSlash(spec.fname))
+		buf, err := ioutil.ReadFile(fname)
+		if err != nil {
+			t.Errorf("ioutil.ReadFile(%q) failed with %v; want success", fname, err)
+			continue
+		}
+		if got := string(buf); got != spec.want {
+			t.Errorf("%s = %q; want %q", spec.fname, got, spec.want)
+		}
+	}
+}
+
+func TestPruneWithLicenses(t *testing.T) {
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"gone/BUILD": `package(default_visibility = ["//visibility:public"])
+
+licenses(["notice"])
+
+go_library(
+    name = "go_default_library",
+    srcs = ["gone.go"],
+)
+`,
+		"kept/BUILD": `licenses(["notice"])
+
+filegroup(
+    name = "data",
+    srcs = ["data.txt"],
+)
+
+go_library(
+    name = "go_default_library",
+    srcs = ["kept.go"],
+)
+`,
+	})
+
+	c := &config{GoPrefix: "example.com/repo", Licenses: []string{"notice"}, DefaultVisibility: []string{"//visibility:public"}}
+	g, emitted := newTestGen(t, dir, c)
+	root := filepath.Join(dir, "...")
+	if err := g.generate(root); err != nil {
+		t.Fatalf("g.generate(%q) failed with %v; want success", root, err)
+	}
+	if f, ok := emitted["gone/BUILD"]; !ok || f != nil {
+		t.Errorf("gone/BUILD is not removed; emitted = %v", emitted)
+	}
+	f := emitted["kept/BUILD"]
+	if f == nil {
+		t.Fatalf("kept/BUILD is not emitted; emitted = %v", emitted)
+	}
+	want := `licenses(["notice"])
+
+filegroup(
+    name = "data",
+    srcs = ["data.txt"],
+)
+`
+	if got := string(bzl.Format(f)); got != want {
+		t.Errorf("kept/BUILD = %s; want %s", got, want)
+	}
+}
+
+func TestPruneDiff(t *testing.T) {
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, pruneTestFiles)
+
+	// Records arguments of the diff command instead of running diff.
+	log := filepath.Join(dir, "diff.log")
+	for key, value := range map[string]string{
+		"BUILDIFIER_DIFF":      fmt.Sprintf("printf '%%s %%s\\n' >>%q", log),
+		"BUILDIFIER_MULTIDIFF": "0",
+	} {
+		orig, ok := os.LookupEnv(key)
+		if err := os.Setenv(key, value); err != nil {
+			t.Fatalf("os.Setenv(%q, %q) failed with %v; want success", key, value, err)
+		}
+		if ok {
+			defer os.Setenv(key, orig)
+		} else {
+			defer os.Unsetenv(key)
+		}
+	}
+
+	c := &config{GoPrefix: "example.com/repo", Mode: "diff"}
+	g, err := newGen(dir, c)
+	if err != nil {
+		t.Fatalf("newGen(%q, %#v) failed with %v; want success", dir, c, err)
+	}
+	root := filepath.Join(dir, "gone")
+	if err := g.generate(root); err != nil {
+		t.Fatalf("g.generate(%q) failed with %v; want success", root, err)
+	}
+
+	buf, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile(%q) failed with %v; want success", log, err)
+	}
+	fname := filepath.Join(dir, "gone", "BUILD")
+	if got, want := string(buf), fmt.Sprintf("%s %s\n", fname, os.DevNull); got != want {
+		t.Errorf("diff arguments = %q; want %q", got, want)
+	}
+	if _, err := os.Stat(fname); err != nil {
+		t.Errorf("os.Stat(%q) failed with %v; want success", fname, err)
+	}
+}
+
+func TestGenerateFlatWithOverlappingRoots(t *testing.T) {
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"a/a.go":     "package a\n",
+		"a/b/b.go":   "package b\n",
+		"a/b/c/c.go": "package c\n",
+		"d/d.go":     "package d\n",
+	})
+
+	c := &config{GoPrefix: "example.com/repo", Flat: true}
+	g, emitted := newTestGen(t, dir, c)
+	roots := []string{
+		filepath.Join(dir, "a", "b", "..."),
+		filepath.Join(dir, "a", "..."),
+		filepath.Join(dir, "a", "b"),
+		filepath.Join(dir, "d"),
+	}
+	if err := g.generateFlat(roots); err != nil {
+		t.Fatalf("g.generateFlat(%q) failed with %v; want success", roots, err)
+	}
+	if len(emitted) != 1 {
+		t.Errorf("emitted = %v; want only BUILD", emitted)
+	}
+	f := emitted["BUILD"]
+	if f == nil {
+		t.Fatalf("BUILD is not emitted; emitted = %v", emitted)
+	}
+	var got []string
+	for _, r := range f.Rules("go_library") {
+		got = append(got, r.Name())
+	}
+	want := []string{"a/b", "a/b/c", "a", "d"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("go_library rules in BUILD = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// orphans is the result of an analysis of the dependency graph of the whole
+// base dir.
+type orphans struct {
+	// unreferenced is the list of labels of libraries which no target out
+	// of their Go packages depends on.
+	unreferenced []string
+	// missing is the list of dependencies on labels in the current
+	// repository which are neither generated nor written in BUILD files.
+	missing []graphEdge
+}
+
+// findOrphans analyzes "gr", which covers the whole base dir.
+func (g *gen) findOrphans(gr *depGraph) (*orphans, error) {
+	nodes := make(map[string]graphNode)
+	for _, n := range gr.Nodes {
+		nodes[n.Label] = n
+	}
+
+	o := new(orphans)
+	referenced := make(map[string]bool)
+	existing := make(map[string]bool)
+	for _, e := range gr.Edges {
+		to := nodes[e.To]
+		if to.Kind == "unknown" {
+			exists, ok := existing[e.To]
+			if !ok {
+				var err error
+				if exists, err = g.hasRule(e.To); err != nil {
+					return nil, err
+				}
+				existing[e.To] = exists
+			}
+			if !exists {
+				o.missing = append(o.missing, e)
+			}
+			continue
+		}
+		if nodes[e.From].Dir != to.Dir {
+			referenced[e.To] = true
+		}
+	}
+	for _, n := range gr.Nodes {
+		if n.Kind == "go_library" && !referenced[n.Label] {
+			o.unreferenced = append(o.unreferenced, n.Label)
+		}
+	}
+	sort.Strings(o.unreferenced)
+	return o, nil
+}
+
+// hasRule determines if the BUILD file under the base dir has a rule labeled
+// "l" in the form of "//pkg:name".
+func (g *gen) hasRule(l string) (bool, error) {
+	i := strings.Index(l, ":")
+	pkg, name := strings.TrimPrefix(l[:i], "//"), l[i+1:]
+	f, err := readBuildFile(filepath.Join(g.base, filepath.FromSlash(pkg), "BUILD"))
+	if err != nil {
+		return false, err
+	}
+	for _, r := range f.Rules("") {
+		if r.Name() == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// orphans writes into "w" libraries generated under the base dir which no
+// other Go package depends on, and dependencies on labels which do not exist
+// in the base dir.
+func (g *gen) orphans(w io.Writer) error {
+	gr, err := g.depGraph(g.defaultRoots(nil))
+	if err != nil {
+		return err
+	}
+	o, err := g.findOrphans(gr)
+	if err != nil {
+		return err
+	}
+	for _, l := range o.unreferenced {
+		if _, err := fmt.Fprintf(w, "unreferenced library: %s\n", l); err != nil {
+			return err
+		}
+	}
+	for _, e := range o.missing {
+		sources := ""
+		if len(e.Sources) > 0 {
+			sources = fmt.Sprintf(" (%s)", strings.Join(e.Sources, ", "))
+		}
+		if _, err := fmt.Fprintf(w, "missing dependency: %s -> %s%s\n", e.From, e.To, sources); err != nil {
+			return err
+		}
+	}
+	return nil
+}
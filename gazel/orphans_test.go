@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestFindOrphans(t *testing.T) {
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"main/main.go":      "package main\n\nimport (\n\t_ \"example.com/repo/used\"\n\t_ \"example.com/repo/hand\"\n\t_ \"example.com/repo/gone\"\n)\n",
+		"used/used.go":      "package used\n",
+		"unused/unused.go":  "package unused\n",
+		"self/self.go":      "package self\n",
+		"self/self_test.go": "package self_test\n\nimport _ \"example.com/repo/self\"\n",
+		"hand/BUILD":        "go_library(name = \"go_default_library\")\n",
+		"gone/BUILD":        "filegroup(name = \"data\")\n",
+	})
+
+	c := &config{GoPrefix: "example.com/repo"}
+	g, _ := newTestGen(t, dir, c)
+	gr, err := g.depGraph(g.defaultRoots(nil))
+	if err != nil {
+		t.Fatalf("g.depGraph(%q) failed with %v; want success", g.defaultRoots(nil), err)
+	}
+	o, err := g.findOrphans(gr)
+	if err != nil {
+		t.Fatalf("g.findOrphans(%#v) failed with %v; want success", gr, err)
+	}
+
+	if got, want := o.unreferenced, []string{"//self:go_default_library", "//unused:go_default_library"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("o.unreferenced = %q; want %q", got, want)
+	}
+	want := []graphEdge{
+		{From: "//main:main", To: "//gone:go_default_library", Sources: []string{"main/main.go:6"}},
+	}
+	if !reflect.DeepEqual(o.missing, want) {
+		t.Errorf("o.missing = %#v; want %#v", o.missing, want)
+	}
+}
@@ -6,12 +6,12 @@ import (
 	bzl "github.com/bazelbuild/buildifier/core"
 )
 
-func printFile(fname string, rules []bzl.Expr) (err error) {
-	buildfile, err := reconcile(fname, rules)
-	if err != nil {
-		return err
+// printFile prints "buildfile" to stdout. It prints nothing if "buildfile"
+// is nil, which means removal of "fname".
+func printFile(fname string, buildfile *bzl.File) (err error) {
+	if buildfile == nil {
+		return nil
 	}
-
 	_, err = os.Stdout.Write(bzl.Format(buildfile))
 	return err
 }
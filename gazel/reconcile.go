@@ -1,31 +1,277 @@
 package main
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 
 	bzl "github.com/bazelbuild/buildifier/core"
+	"github.com/yugui/gazel/generator"
 )
 
+// goRuleKinds is the list of kinds of rules which gazel manages.
+var goRuleKinds = []string{"go_library", "go_binary", "go_test"}
+
+// statementKinds is the list of kinds of unnamed top-level calls which gazel
+// manages. A BUILD file has at most one call of each kind.
+var statementKinds = []string{"package", "licenses", "go_prefix"}
+
 func reconcile(fname string, rules []bzl.Expr) (*bzl.File, error) {
+	orig, err := readBuildFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	newfile := *orig
+	// TODO(yugui) Respect existing data, visibility and other attributes of
+	// rules, and their positions.
+	_, removed := delManagedRules(&newfile)
+	for _, expr := range rules {
+		call, ok := expr.(*bzl.CallExpr)
+		if !ok {
+			continue
+		}
+		r := &bzl.Rule{Call: call}
+		if c, ok := removed[ruleKey{kind: r.Kind(), name: r.Name()}]; ok {
+			call.Comments = c.comments
+			if c.block != nil {
+				newfile.Stmt = removeStmt(newfile.Stmt, c.block)
+			}
+		}
+	}
+	rules = reconcileStatements(&newfile, rules)
+	if err := checkConflicts(fname, &newfile, rules); err != nil {
+		return nil, err
+	}
+	newfile.Stmt = append(newfile.Stmt, rules...)
+	return &newfile, nil
+}
+
+// reconcileStatements merges calls of statementKinds in "exprs" into "f", and
+// returns the rest of "exprs".
+// Existing calls in "f" are replaced in place keeping their comments, and
+// duplicates of them are removed. New calls are inserted after the leading
+// loads in the order of statementKinds.
+func reconcileStatements(f *bzl.File, exprs []bzl.Expr) []bzl.Expr {
+	stmts := make(map[string]*bzl.CallExpr)
+	var rest []bzl.Expr
+	for _, expr := range exprs {
+		if kind := callKind(expr); isStatementKind(kind) {
+			stmts[kind] = expr.(*bzl.CallExpr)
+			continue
+		}
+		rest = append(rest, expr)
+	}
+	if len(stmts) == 0 {
+		return rest
+	}
+
+	var list []bzl.Expr
+	found := make(map[string]bool)
+	for _, stmt := range f.Stmt {
+		kind := callKind(stmt)
+		if call, ok := stmts[kind]; ok {
+			if found[kind] {
+				continue
+			}
+			found[kind] = true
+			call.Comments = stmt.(*bzl.CallExpr).Comments
+			stmt = call
+		}
+		list = append(list, stmt)
+	}
+
+	pos := 0
+	for pos < len(list) && callKind(list[pos]) == "load" {
+		pos++
+	}
+	var added []bzl.Expr
+	for _, kind := range statementKinds {
+		if call, ok := stmts[kind]; ok && !found[kind] {
+			added = append(added, call)
+		}
+	}
+	f.Stmt = append(append(append([]bzl.Expr(nil), list[:pos]...), added...), list[pos:]...)
+	return rest
+}
+
+// ruleKey identifies a rule in a BUILD file.
+type ruleKey struct {
+	kind, name string
+}
+
+// removedComments is the comments of a rule removed by delManagedRules.
+type removedComments struct {
+	comments bzl.Comments
+	// block keeps "gazel:" directives in the comments in place of the rule.
+	// It is nil if the comments have no directives.
+	block *bzl.CommentBlock
+}
+
+// delManagedRules removes rules which gazel manages from "f", i.e. rules of
+// goRuleKinds and rules tagged with generator.AutomanagedTag.
+// Other rules, e.g. hand-written genrules, are kept.
+// Directives in comments on the removed rules are kept in their places, so
+// that they survive even if the rules are not generated again.
+// It returns the number of removed rules, and their comments.
+func delManagedRules(f *bzl.File) (int, map[ruleKey]removedComments) {
+	var n int
+	removed := make(map[ruleKey]removedComments)
+	var stmts []bzl.Expr
+	for _, stmt := range f.Stmt {
+		call, ok := stmt.(*bzl.CallExpr)
+		if !ok || !isManagedRule(&bzl.Rule{Call: call}) {
+			stmts = append(stmts, stmt)
+			continue
+		}
+		n++
+		r := &bzl.Rule{Call: call}
+		c := removedComments{comments: call.Comments}
+		var directives []bzl.Comment
+		for _, list := range [][]bzl.Comment{call.Before, call.Suffix, call.After} {
+			for _, com := range list {
+				if len(generator.ParseDirectives([]byte(com.Token))) > 0 {
+					directives = append(directives, com)
+				}
+			}
+		}
+		if len(directives) > 0 {
+			c.block = &bzl.CommentBlock{Comments: bzl.Comments{After: directives}}
+			stmts = append(stmts, c.block)
+		}
+		removed[ruleKey{kind: r.Kind(), name: r.Name()}] = c
+	}
+	f.Stmt = stmts
+	return n, removed
+}
+
+// removeStmt returns "stmts" without "stmt".
+func removeStmt(stmts []bzl.Expr, stmt bzl.Expr) []bzl.Expr {
+	var result []bzl.Expr
+	for _, s := range stmts {
+		if s != stmt {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func isManagedRule(r *bzl.Rule) bool {
+	for _, kind := range goRuleKinds {
+		if r.Kind() == kind {
+			return true
+		}
+	}
+	for _, tag := range r.AttrStrings("tags") {
+		if tag == generator.AutomanagedTag {
+			return true
+		}
+	}
+	return false
+}
+
+// callKind returns the name of the function which "expr" calls, or an empty
+// string if "expr" is not a call of a function by name.
+func callKind(expr bzl.Expr) string {
+	call, ok := expr.(*bzl.CallExpr)
+	if !ok {
+		return ""
+	}
+	x, ok := call.X.(*bzl.LiteralExpr)
+	if !ok {
+		return ""
+	}
+	return x.Token
+}
+
+func isStatementKind(kind string) bool {
+	for _, k := range statementKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// checkConflicts returns an error if any of "rules" has the same name as
+// a rule in "f" loaded from "fname".
+func checkConflicts(fname string, f *bzl.File, rules []bzl.Expr) error {
+	existing := make(map[string]string)
+	for _, r := range f.Rules("") {
+		if name := r.Name(); name != "" {
+			existing[name] = r.Kind()
+		}
+	}
+	for _, expr := range rules {
+		call, ok := expr.(*bzl.CallExpr)
+		if !ok {
+			continue
+		}
+		r := &bzl.Rule{Call: call}
+		if kind, ok := existing[r.Name()]; ok {
+			return fmt.Errorf("%s: generated rule %q conflicts with an existing %s rule", fname, r.Name(), kind)
+		}
+	}
+	return nil
+}
+
+// readBuildFile parses the BUILD file "fname".
+// It returns an empty file if "fname" does not exist.
+func readBuildFile(fname string) (*bzl.File, error) {
 	buf, err := ioutil.ReadFile(fname)
 	if err != nil && !os.IsNotExist(err) {
 		return nil, err
 	}
-	orig := new(bzl.File)
-	if len(buf) > 0 {
-		orig, err = bzl.Parse(fname, buf)
-		if err != nil {
-			return nil, err
+	if len(buf) == 0 {
+		return new(bzl.File), nil
+	}
+	return bzl.Parse(fname, buf)
+}
+
+// prune removes rules which gazel manages from the BUILD file "fname" in a
+// directory without Go packages, and also statements of statementKinds if no
+// rules remain.
+// It returns nil if the file does not exist or has no such rules.
+// "empty" is true if nothing but loads without comments remains in the file.
+func prune(fname string) (f *bzl.File, empty bool, err error) {
+	f, err = readBuildFile(fname)
+	if err != nil {
+		return nil, false, err
+	}
+	if n, _ := delManagedRules(f); n == 0 {
+		return nil, false, nil
+	}
+
+	// Statements which gazel generates are meaningless without rules.
+	if !hasRules(f) {
+		var stmts []bzl.Expr
+		for _, stmt := range f.Stmt {
+			c := stmt.Comment()
+			if isStatementKind(callKind(stmt)) && len(c.Before) == 0 && len(c.Suffix) == 0 && len(c.After) == 0 {
+				continue
+			}
+			stmts = append(stmts, stmt)
 		}
+		f.Stmt = stmts
 	}
 
-	newfile := *orig
-	// TODO(yugui) Respect existing data, visibility and other attributes;
-	// comments on rules; and their positions.
-	newfile.DelRules("go_library", "")
-	newfile.DelRules("go_binary", "")
-	newfile.DelRules("go_test", "")
-	newfile.Stmt = append(newfile.Stmt, rules...)
-	return &newfile, nil
+	for _, stmt := range f.Stmt {
+		if callKind(stmt) != "load" {
+			return f, false, nil
+		}
+		if c := stmt.Comment(); len(c.Before) > 0 || len(c.Suffix) > 0 || len(c.After) > 0 {
+			return f, false, nil
+		}
+	}
+	return f, true, nil
+}
+
+// hasRules determines if "f" has calls other than loads and statementKinds.
+func hasRules(f *bzl.File) bool {
+	for _, stmt := range f.Stmt {
+		if kind := callKind(stmt); kind != "" && kind != "load" && !isStatementKind(kind) {
+			return true
+		}
+	}
+	return false
 }
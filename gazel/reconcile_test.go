@@ -0,0 +1,342 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+	"github.com/yugui/gazel/generator"
+)
+
+// parseRules parses "content" as a list of top-level statements.
+func parseRules(t *testing.T, content string) []bzl.Expr {
+	f, err := bzl.Parse("BUILD", []byte(content))
+	if err != nil {
+		t.Fatalf("bzl.Parse(%q, %q) failed with %v; want success", "BUILD", content, err)
+	}
+	return f.Stmt
+}
+
+// reconcileFile writes "orig" into a BUILD file in a temporary directory and
+// reconciles it with "generated".
+func reconcileFile(t *testing.T, orig, generated string) (string, error) {
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{"BUILD": orig})
+
+	fname := filepath.Join(dir, "BUILD")
+	f, err := reconcile(fname, parseRules(t, generated))
+	if err != nil {
+		return "", err
+	}
+	return string(bzl.Format(f)), nil
+}
+
+// canonicalBuild formats "content" in the canonical form of BUILD files.
+func canonicalBuild(t *testing.T, content string) string {
+	f, err := bzl.Parse("BUILD", []byte(content))
+	if err != nil {
+		t.Fatalf("bzl.Parse(%q, %q) failed with %v; want success", "BUILD", content, err)
+	}
+	return string(bzl.Format(f))
+}
+
+func TestReconcileKeepsHandWrittenGenrules(t *testing.T) {
+	got, err := reconcileFile(t, `
+genrule(
+    name = "gen",
+    outs = ["gen.go"],
+    cmd = "echo package p > $@",
+)
+
+genrule(
+    name = "stale_swig",
+    outs = ["stale_swig.go"],
+    cmd = "swig",
+    tags = ["automanaged"],
+)
+
+go_library(
+    name = "go_default_library",
+    srcs = ["old.go"],
+)
+`, `
+genrule(
+    name = "wrap_swig",
+    outs = ["wrap_swig.go"],
+    cmd = "swig",
+    tags = ["automanaged"],
+)
+
+go_library(
+    name = "go_default_library",
+    srcs = ["wrap_swig.go"],
+)
+`)
+	if err != nil {
+		t.Fatalf("reconcile failed with %v; want success", err)
+	}
+	want := canonicalBuild(t, `
+genrule(
+    name = "gen",
+    outs = ["gen.go"],
+    cmd = "echo package p > $@",
+)
+
+genrule(
+    name = "wrap_swig",
+    outs = ["wrap_swig.go"],
+    cmd = "swig",
+    tags = ["automanaged"],
+)
+
+go_library(
+    name = "go_default_library",
+    srcs = ["wrap_swig.go"],
+)
+`)
+	if got != want {
+		t.Errorf("reconcile = %s; want %s", got, want)
+	}
+}
+
+func TestReconcileConflictsWithHandWrittenGenrule(t *testing.T) {
+	_, err := reconcileFile(t, `
+genrule(
+    name = "wrap_swig",
+    outs = ["wrap_swig.go"],
+    cmd = "my_swig",
+)
+`, `
+genrule(
+    name = "wrap_swig",
+    outs = ["wrap_swig.go"],
+    cmd = "swig",
+    tags = ["automanaged"],
+)
+`)
+	if err == nil || !strings.Contains(err.Error(), `"wrap_swig"`) {
+		t.Errorf("reconcile returned %v; want a conflict on %q", err, "wrap_swig")
+	}
+}
+
+func TestPruneRemovesStaleGenrules(t *testing.T) {
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{"BUILD": `
+genrule(
+    name = "wrap_swig",
+    outs = ["wrap_swig.go"],
+    cmd = "swig",
+    tags = ["automanaged"],
+)
+`})
+
+	fname := filepath.Join(dir, "BUILD")
+	f, empty, err := prune(fname)
+	if err != nil {
+		t.Fatalf("prune(%q) failed with %v; want success", fname, err)
+	}
+	if f == nil || !empty {
+		t.Errorf("prune(%q) = %v, %v; want a file, true", fname, f, empty)
+	}
+}
+
+func TestReconcileStatements(t *testing.T) {
+	for _, spec := range []struct {
+		name, orig, generated, want string
+	}{
+		{
+			name: "in place",
+			orig: `
+load("@io_bazel_rules_go//go:def.bzl", "go_prefix")
+
+go_prefix("example.com/old")
+
+filegroup(name = "data")
+`,
+			generated: `go_prefix("example.com/new")`,
+			want: `
+load("@io_bazel_rules_go//go:def.bzl", "go_prefix")
+
+go_prefix("example.com/new")
+
+filegroup(name = "data")
+`,
+		},
+		{
+			name: "comments",
+			orig: `
+# The prefix of the repository.
+go_prefix("example.com/old")  # keep in sync with go.mod
+`,
+			generated: `go_prefix("example.com/new")`,
+			want: `
+# The prefix of the repository.
+go_prefix("example.com/new")  # keep in sync with go.mod
+`,
+		},
+		{
+			name: "duplicates",
+			orig: `
+licenses(["notice"])
+
+go_prefix("example.com/old")
+
+licenses(["restricted"])
+`,
+			generated: `licenses(["notice"])`,
+			want: `
+licenses(["notice"])
+
+go_prefix("example.com/old")
+`,
+		},
+		{
+			name: "after loads",
+			orig: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+filegroup(name = "data")
+`,
+			generated: `
+go_prefix("example.com/repo")
+
+licenses(["notice"])
+
+package(default_visibility = ["//visibility:public"])
+`,
+			want: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+package(default_visibility = ["//visibility:public"])
+
+licenses(["notice"])
+
+go_prefix("example.com/repo")
+
+filegroup(name = "data")
+`,
+		},
+	} {
+		f, err := bzl.Parse("BUILD", []byte(spec.orig))
+		if err != nil {
+			t.Fatalf("%s: bzl.Parse(%q, %q) failed with %v; want success", spec.name, "BUILD", spec.orig, err)
+		}
+		lib := parseRules(t, `go_library(name = "go_default_library")`)
+		exprs := append(parseRules(t, spec.generated), lib...)
+
+		rest := reconcileStatements(f, exprs)
+		if got, want := string(bzl.Format(f)), canonicalBuild(t, spec.want); got != want {
+			t.Errorf("%s: reconcileStatements(f, exprs) = %s; want %s", spec.name, got, want)
+		}
+		if len(rest) != 1 || rest[0] != lib[0] {
+			t.Errorf("%s: reconcileStatements(f, exprs) returned %v; want %v", spec.name, rest, lib)
+		}
+	}
+}
+
+func TestGenerateStatementsWithoutGoFiles(t *testing.T) {
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"BUILD": `
+load("@io_bazel_rules_go//go:def.bzl", "go_prefix")
+
+go_prefix("example.com/old")
+
+filegroup(name = "data")
+`,
+	})
+
+	c := &config{GoPrefix: "example.com/repo", Licenses: []string{"notice"}}
+	g, emitted := newTestGen(t, dir, c)
+	if err := g.generate(dir); err != nil {
+		t.Fatalf("g.generate(%q) failed with %v; want success", dir, err)
+	}
+	f := emitted["BUILD"]
+	if f == nil {
+		t.Fatalf("BUILD is not emitted; emitted = %v", emitted)
+	}
+	want := canonicalBuild(t, `
+load("@io_bazel_rules_go//go:def.bzl", "go_prefix")
+
+licenses(["notice"])
+
+go_prefix("example.com/repo")
+
+filegroup(name = "data")
+`)
+	if got := string(bzl.Format(f)); got != want {
+		t.Errorf("BUILD = %s; want %s", got, want)
+	}
+}
+
+func TestReconcileKeepsCommentsOfRules(t *testing.T) {
+	got, err := reconcileFile(t, `
+# gazel:build_tags foo
+# The library.
+go_library(
+    name = "go_default_library",
+    srcs = ["old.go"],
+)  # keep
+
+# gazel:exclude gen.go
+go_test(
+    name = "go_default_test",
+    srcs = ["old_test.go"],
+)
+`, `
+go_library(
+    name = "go_default_library",
+    srcs = ["foo.go"],
+)
+`)
+	if err != nil {
+		t.Fatalf("reconcile failed with %v; want success", err)
+	}
+	want := canonicalBuild(t, `
+# gazel:exclude gen.go
+
+# gazel:build_tags foo
+# The library.
+go_library(
+    name = "go_default_library",
+    srcs = ["foo.go"],
+)  # keep
+`)
+	if got != want {
+		t.Errorf("reconcile = %s; want %s", got, want)
+	}
+
+	// Directives survive another run.
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{"BUILD": got})
+	ds, err := generator.ReadDirectives(dir)
+	if err != nil {
+		t.Fatalf("generator.ReadDirectives(%q) failed with %v; want success", dir, err)
+	}
+	wantDirectives := []generator.Directive{
+		{Key: "exclude", Value: "gen.go"},
+		{Key: "build_tags", Value: "foo"},
+	}
+	if !reflect.DeepEqual(ds, wantDirectives) {
+		t.Errorf("generator.ReadDirectives(%q) = %#v; want %#v", dir, ds, wantDirectives)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+	"github.com/yugui/gazel/generator"
+)
+
+// lockFiles maps paths to lock files of legacy dependency management tools
+// relative to the base dir into their parsers.
+// Parsers take rules for generator.RepoRoot if the lock files don't record
+// roots of repositories.
+var lockFiles = []struct {
+	path  string
+	parse func(data []byte, rules ...*regexp.Regexp) ([]generator.Dependency, error)
+}{
+	{path: "Gopkg.lock", parse: withRoots(generator.ParseGopkgLock)},
+	{path: "glide.lock", parse: withRoots(generator.ParseGlideLock)},
+	{path: filepath.Join("Godeps", "Godeps.json"), parse: generator.ParseGodeps},
+	{path: filepath.Join("vendor", "vendor.json"), parse: generator.ParseVendorJSON},
+}
+
+// withRoots adapts "parse" of a lock file which records roots of
+// repositories to the type of parsers in lockFiles.
+func withRoots(parse func(data []byte) ([]generator.Dependency, error)) func(data []byte, rules ...*regexp.Regexp) ([]generator.Dependency, error) {
+	return func(data []byte, _ ...*regexp.Regexp) ([]generator.Dependency, error) {
+		return parse(data)
+	}
+}
+
+// updateRepos updates go_repository rules in the WORKSPACE file in "base".
+// The rules are generated from "from", which is go.mod or a lock file of a
+// legacy dependency management tool.
+// If "from" is empty, updateRepos looks for go.mod or lock files in "base".
+// "rules" are passed to generator.RepoRoot to determine the roots of
+// repositories in lock files.
+// The updated WORKSPACE file is output with "emit".
+func updateRepos(base, from string, rules []*regexp.Regexp, emit func(fname string, f *bzl.File) error) error {
+	if from == "" {
+		candidates := []string{"go.mod"}
+		for _, l := range lockFiles {
+			candidates = append(candidates, l.path)
+		}
+		for _, c := range candidates {
+			if _, err := os.Stat(filepath.Join(base, c)); err == nil {
+				from = filepath.Join(base, c)
+				break
+			}
+		}
+		if from == "" {
+			return fmt.Errorf("none of %s found in %s", strings.Join(candidates, ", "), base)
+		}
+	}
+
+	var (
+		repos []*bzl.Rule
+		err   error
+	)
+	if filepath.Base(from) == "go.mod" {
+		repos, err = modRepos(from)
+	} else {
+		repos, err = lockedRepos(from, rules)
+	}
+	if err != nil {
+		return err
+	}
+
+	fname := filepath.Join(base, "WORKSPACE")
+	f, err := reconcileRepos(fname, repos)
+	if err != nil {
+		return err
+	}
+	return emit(fname, f)
+}
+
+// modRepos generates go_repository rules from the go.mod file "fname" and
+// go.sum next to it.
+func modRepos(fname string) ([]*bzl.Rule, error) {
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	mf, err := generator.ParseModFile(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fname, err)
+	}
+	for _, r := range mf.Replace {
+		if r.IsLocal() {
+			log.Printf("skipping %s replaced with a local directory %s", r.OldPath, r.Path)
+		}
+	}
+
+	sums := make(map[string]string)
+	sumfile := filepath.Join(filepath.Dir(fname), "go.sum")
+	buf, err = ioutil.ReadFile(sumfile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(buf) > 0 {
+		if sums, err = generator.ParseSumFile(buf); err != nil {
+			return nil, fmt.Errorf("%s: %v", sumfile, err)
+		}
+	}
+	return generator.GenerateRepositories(mf, sums)
+}
+
+// lockedRepos generates go_repository rules from the lock file "fname" of
+// a legacy dependency management tool.
+func lockedRepos(fname string, rules []*regexp.Regexp) ([]*bzl.Rule, error) {
+	for _, l := range lockFiles {
+		if !strings.HasSuffix(filepath.Clean(fname), l.path) {
+			continue
+		}
+		buf, err := ioutil.ReadFile(fname)
+		if err != nil {
+			return nil, err
+		}
+		deps, err := l.parse(buf, rules...)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fname, err)
+		}
+		return generator.GenerateLockedRepositories(deps)
+	}
+	return nil, fmt.Errorf("unrecognized kind of lock file %s", fname)
+}
+
+// goRepositoryBzl is the file which defines go_repository with the "version"
+// and "sum" attributes of modules.
+const goRepositoryBzl = "@bazel_gazelle//:deps.bzl"
+
+// conflictingAttrs maps an attribute of go_repository into attributes which
+// cannot be specified together.
+var conflictingAttrs = map[string][]string{
+	"commit":  {"replace", "sum", "tag", "version"},
+	"version": {"commit", "replace", "sum", "tag"},
+}
+
+// reconcileRepos merges go_repository rules "rules" into the WORKSPACE file
+// "fname".
+// Existing rules are updated in place. Attributes which "rules" don't have
+// are kept as they are unless they conflict with the new ones.
+func reconcileRepos(fname string, rules []*bzl.Rule) (*bzl.File, error) {
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	f := new(bzl.File)
+	if len(buf) > 0 {
+		f, err = bzl.Parse(fname, buf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	existing := make(map[string]*bzl.Rule)
+	for _, r := range f.Rules("go_repository") {
+		existing[r.Name()] = r
+	}
+
+	var added bool
+	for _, r := range rules {
+		e, ok := existing[r.Name()]
+		if !ok {
+			// go_repository must be available if the file already uses it.
+			if !added && len(existing) == 0 && !loads(f, "go_repository") {
+				f.Stmt = append(f.Stmt, loadCall(goRepositoryBzl, "go_repository"))
+			}
+			f.Stmt = append(f.Stmt, r.Call)
+			added = true
+			continue
+		}
+		for _, key := range r.AttrKeys() {
+			if key == "name" {
+				continue
+			}
+			e.SetAttr(key, r.Attr(key))
+			for _, c := range conflictingAttrs[key] {
+				if r.Attr(c) == nil {
+					e.DelAttr(c)
+				}
+			}
+		}
+	}
+	return f, nil
+}
+
+// loads determines if "f" loads the symbol "sym".
+func loads(f *bzl.File, sym string) bool {
+	for _, stmt := range f.Stmt {
+		call, ok := stmt.(*bzl.CallExpr)
+		if !ok {
+			continue
+		}
+		if x, ok := call.X.(*bzl.LiteralExpr); !ok || x.Token != "load" {
+			continue
+		}
+		for i, arg := range call.List {
+			if s, ok := arg.(*bzl.StringExpr); ok && i > 0 && s.Value == sym {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func loadCall(file string, syms ...string) *bzl.CallExpr {
+	list := []bzl.Expr{&bzl.StringExpr{Value: file}}
+	for _, s := range syms {
+		list = append(list, &bzl.StringExpr{Value: s})
+	}
+	return &bzl.CallExpr{
+		X:    &bzl.LiteralExpr{Token: "load"},
+		List: list,
+	}
+}
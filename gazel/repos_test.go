@@ -0,0 +1,184 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+)
+
+func TestReconcileRepos(t *testing.T) {
+	for _, spec := range []struct {
+		name, orig, generated, want string
+	}{
+		{
+			name: "new",
+			generated: `
+go_repository(
+    name = "com_example_x",
+    importpath = "example.com/x",
+    version = "v1.0.0",
+    sum = "h1:x=",
+)
+`,
+			want: `
+load(
+    "@bazel_gazelle//:deps.bzl",
+    "go_repository",
+)
+
+go_repository(
+    name = "com_example_x",
+    importpath = "example.com/x",
+    version = "v1.0.0",
+    sum = "h1:x=",
+)
+`,
+		},
+		{
+			name: "version without sum",
+			orig: `
+load("@bazel_gazelle//:deps.bzl", "go_repository")
+
+go_repository(
+    name = "com_example_x",
+    importpath = "example.com/x",
+    version = "v1.0.0",
+    sum = "h1:x=",
+    build_file_proto_mode = "disable",
+)
+`,
+			generated: `
+go_repository(
+    name = "com_example_x",
+    importpath = "example.com/x",
+    version = "v1.1.0",
+)
+`,
+			want: `
+load("@bazel_gazelle//:deps.bzl", "go_repository")
+
+go_repository(
+    name = "com_example_x",
+    importpath = "example.com/x",
+    version = "v1.1.0",
+    build_file_proto_mode = "disable",
+)
+`,
+		},
+		{
+			name: "replace removed",
+			orig: `
+load("@bazel_gazelle//:deps.bzl", "go_repository")
+
+go_repository(
+    name = "com_github_foo_bar",
+    importpath = "github.com/foo/bar",
+    replace = "github.com/fork/bar",
+    version = "v1.0.0",
+    sum = "h1:fork=",
+)
+`,
+			generated: `
+go_repository(
+    name = "com_github_foo_bar",
+    importpath = "github.com/foo/bar",
+    version = "v1.2.0",
+    sum = "h1:bar=",
+)
+`,
+			want: `
+load("@bazel_gazelle//:deps.bzl", "go_repository")
+
+go_repository(
+    name = "com_github_foo_bar",
+    importpath = "github.com/foo/bar",
+    version = "v1.2.0",
+    sum = "h1:bar=",
+)
+`,
+		},
+		{
+			name: "replace kept",
+			orig: `
+load("@bazel_gazelle//:deps.bzl", "go_repository")
+
+go_repository(
+    name = "com_github_foo_bar",
+    importpath = "github.com/foo/bar",
+    replace = "github.com/fork/bar",
+    version = "v1.0.0",
+)
+`,
+			generated: `
+go_repository(
+    name = "com_github_foo_bar",
+    importpath = "github.com/foo/bar",
+    replace = "github.com/fork/bar",
+    version = "v1.1.0",
+)
+`,
+			want: `
+load("@bazel_gazelle//:deps.bzl", "go_repository")
+
+go_repository(
+    name = "com_github_foo_bar",
+    importpath = "github.com/foo/bar",
+    replace = "github.com/fork/bar",
+    version = "v1.1.0",
+)
+`,
+		},
+		{
+			name: "commit",
+			orig: `
+load("@bazel_gazelle//:deps.bzl", "go_repository")
+
+go_repository(
+    name = "com_example_x",
+    importpath = "example.com/x",
+    version = "v1.0.0",
+    sum = "h1:x=",
+)
+`,
+			generated: `
+go_repository(
+    name = "com_example_x",
+    importpath = "example.com/x",
+    commit = "0123456789abcdef",
+)
+`,
+			want: `
+load("@bazel_gazelle//:deps.bzl", "go_repository")
+
+go_repository(
+    name = "com_example_x",
+    importpath = "example.com/x",
+    commit = "0123456789abcdef",
+)
+`,
+		},
+	} {
+		dir, err := tempDir()
+		if err != nil {
+			t.Fatalf("tempDir() failed with %v; want success", err)
+		}
+		defer os.RemoveAll(dir)
+		writeFiles(t, dir, map[string]string{"WORKSPACE": spec.orig})
+
+		var rules []*bzl.Rule
+		for _, expr := range parseRules(t, spec.generated) {
+			rules = append(rules, &bzl.Rule{Call: expr.(*bzl.CallExpr)})
+		}
+		fname := filepath.Join(dir, "WORKSPACE")
+		f, err := reconcileRepos(fname, rules)
+		if err != nil {
+			t.Errorf("%s: reconcileRepos(%q, rules) failed with %v; want success", spec.name, fname, err)
+			continue
+		}
+		if got, want := string(bzl.Format(f)), canonicalBuild(t, spec.want); got != want {
+			t.Errorf("%s: reconcileRepos(%q, rules) = %s; want %s", spec.name, fname, got, want)
+		}
+	}
+}
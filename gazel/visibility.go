@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+	"github.com/yugui/gazel/generator"
+)
+
+// reverseDeps generates rules for all the Go packages under the base dir, and
+// returns a map from labels of go_library rules to the set of Bazel packages
+// whose Go rules depend on them.
+// Labels in the map are in the form of "//pkg:name".
+// The generated rules are kept in g.generated.
+func (g *gen) reverseDeps() (map[string]map[string]bool, error) {
+	users := make(map[string]map[string]bool)
+	g.generated = make(map[string][]*bzl.Rule)
+	err := generator.Walk(g.bctx, g.base, g.configs[""], func(pkg *build.Package, c *generator.Config) error {
+		rel, err := filepath.Rel(g.base, pkg.Dir)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			rel = ""
+		}
+		rel = filepath.ToSlash(rel)
+
+		rs, err := g.g.Generate(rel, pkg, c)
+		if err != nil {
+			return err
+		}
+		g.generated[rel] = rs
+		for _, r := range rs {
+			for _, dep := range stringsIn(r.Attr("deps")) {
+				l, ok := absLabel(dep, rel)
+				if !ok {
+					continue
+				}
+				if users[l] == nil {
+					users[l] = make(map[string]bool)
+				}
+				users[l][rel] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// restrictVisibility sets the narrowest visibility which allows the users in
+// g.users to go_library rules in "rs" generated for the package at "rel".
+func (g *gen) restrictVisibility(rel string, rs []*bzl.Rule) {
+	for _, r := range rs {
+		if r.Kind() != "go_library" {
+			continue
+		}
+		l := fmt.Sprintf("//%s:%s", rel, r.Name())
+		list := new(bzl.ListExpr)
+		for _, v := range minimalVisibility(rel, g.users[l]) {
+			list.List = append(list.List, &bzl.StringExpr{Value: v})
+		}
+		r.SetAttr("visibility", list)
+	}
+}
+
+// minimalVisibility returns the narrowest visibility of a rule in the Bazel
+// package "rel" which allows Bazel packages in "users" to depend on it.
+// Users under "rel" are allowed as its subpackages, and others are allowed
+// one by one.
+func minimalVisibility(rel string, users map[string]bool) []string {
+	var vis []string
+	var subpackages bool
+	for u := range users {
+		switch {
+		case u == rel:
+			// The same package is always visible.
+		case rel == "" || strings.HasPrefix(u, rel+"/"):
+			subpackages = true
+		default:
+			vis = append(vis, fmt.Sprintf("//%s:__pkg__", u))
+		}
+	}
+	sort.Strings(vis)
+	if subpackages {
+		vis = append([]string{fmt.Sprintf("//%s:__subpackages__", rel)}, vis...)
+	}
+	if len(vis) == 0 {
+		return []string{"//visibility:private"}
+	}
+	return vis
+}
+
+// absLabel converts a label "l" referenced from the Bazel package "rel" in
+// the current repository into the form of "//pkg:name".
+// It returns false if "l" is in another repository.
+func absLabel(l, rel string) (string, bool) {
+	switch {
+	case strings.HasPrefix(l, ":"):
+		return fmt.Sprintf("//%s%s", rel, l), true
+	case strings.HasPrefix(l, "//"):
+		if !strings.Contains(l, ":") {
+			return fmt.Sprintf("%s:%s", l, l[strings.LastIndex(l, "/")+1:]), true
+		}
+		return l, true
+	}
+	return "", false
+}
+
+// stringsIn returns string literals in "expr" except keys of dictionaries,
+// e.g. labels in a list of labels possibly combined with select().
+func stringsIn(expr bzl.Expr) []string {
+	switch x := expr.(type) {
+	case *bzl.StringExpr:
+		return []string{x.Value}
+	case *bzl.ListExpr:
+		var s []string
+		for _, e := range x.List {
+			s = append(s, stringsIn(e)...)
+		}
+		return s
+	case *bzl.BinaryExpr:
+		return append(stringsIn(x.X), stringsIn(x.Y)...)
+	case *bzl.CallExpr:
+		var s []string
+		for _, e := range x.List {
+			s = append(s, stringsIn(e)...)
+		}
+		return s
+	case *bzl.DictExpr:
+		var s []string
+		for _, e := range x.List {
+			s = append(s, stringsIn(e)...)
+		}
+		return s
+	case *bzl.KeyValueExpr:
+		return stringsIn(x.Value)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMinimalVisibility(t *testing.T) {
+	for _, spec := range []struct {
+		name  string
+		rel   string
+		users []string
+		want  []string
+	}{
+		{
+			name: "no users",
+			rel:  "a",
+			want: []string{"//visibility:private"},
+		},
+		{
+			name:  "same package",
+			rel:   "a",
+			users: []string{"a"},
+			want:  []string{"//visibility:private"},
+		},
+		{
+			name:  "subpackages",
+			rel:   "a",
+			users: []string{"a", "a/b", "a/b/c"},
+			want:  []string{"//a:__subpackages__"},
+		},
+		{
+			name:  "elsewhere",
+			rel:   "a",
+			users: []string{"ab", "c/d", "a/b"},
+			want:  []string{"//a:__subpackages__", "//ab:__pkg__", "//c/d:__pkg__"},
+		},
+		{
+			name:  "root",
+			rel:   "",
+			users: []string{"", "a"},
+			want:  []string{"//:__subpackages__"},
+		},
+	} {
+		users := make(map[string]bool)
+		for _, u := range spec.users {
+			users[u] = true
+		}
+		if got := minimalVisibility(spec.rel, users); !reflect.DeepEqual(got, spec.want) {
+			t.Errorf("%s: minimalVisibility(%q, %v) = %q; want %q", spec.name, spec.rel, users, got, spec.want)
+		}
+	}
+}
+
+func TestAbsLabel(t *testing.T) {
+	for _, spec := range []struct {
+		l, rel string
+		want   string
+		ok     bool
+	}{
+		{l: ":lib", rel: "a/b", want: "//a/b:lib", ok: true},
+		{l: ":lib", rel: "", want: "//:lib", ok: true},
+		{l: "//a/b:lib", rel: "c", want: "//a/b:lib", ok: true},
+		{l: "//a/b", rel: "c", want: "//a/b:b", ok: true},
+		{l: "@com_example_x//:go_default_library", rel: "c"},
+	} {
+		got, ok := absLabel(spec.l, spec.rel)
+		if got != spec.want || ok != spec.ok {
+			t.Errorf("absLabel(%q, %q) = %q, %v; want %q, %v", spec.l, spec.rel, got, ok, spec.want, spec.ok)
+		}
+	}
+}
+
+func TestMinimalVisibilityWarnsOnce(t *testing.T) {
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"p/p_test.go": "package p_test\n\nimport _ \"example.com/repo/p\"\n",
+	})
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	c := &config{GoPrefix: "example.com/repo", MinimalVisibility: true}
+	g, emitted := newTestGen(t, dir, c)
+	if g.users, err = g.reverseDeps(); err != nil {
+		t.Fatalf("g.reverseDeps() failed with %v; want success", err)
+	}
+	root := filepath.Join(dir, "...")
+	if err := g.generate(root); err != nil {
+		t.Fatalf("g.generate(%q) failed with %v; want success", root, err)
+	}
+	if _, ok := emitted["p/BUILD"]; !ok {
+		t.Errorf("p/BUILD is not emitted; emitted = %v", emitted)
+	}
+	if got := strings.Count(buf.String(), "skipping it"); got != 1 {
+		t.Errorf("warnings = %q; want one warning", buf.String())
+	}
+}
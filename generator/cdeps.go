@@ -0,0 +1,191 @@
+package generator
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A CDepsMap maps C/C++ dependencies of cgo packages into labels of
+// C/C++ rules in Bazel.
+type CDepsMap struct {
+	// PkgConfig maps names of packages in "#cgo pkg-config" directives into
+	// labels.
+	PkgConfig map[string]string
+	// Includes maps paths or their prefixes in "#include" directives into
+	// labels. The longest prefix wins.
+	Includes map[string]string
+}
+
+// ParseCDepsMap parses a mapping table of C/C++ dependencies.
+// Each line of the table is one of:
+//
+//	pkg-config NAME LABEL
+//	include PATH-OR-PREFIX LABEL
+//
+// Empty lines and lines starting with "#" are ignored.
+func ParseCDepsMap(data []byte) (CDepsMap, error) {
+	m := CDepsMap{
+		PkgConfig: make(map[string]string),
+		Includes:  make(map[string]string),
+	}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; s.Scan(); lineno++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return CDepsMap{}, fmt.Errorf("line %d: want KIND KEY LABEL", lineno)
+		}
+		switch fields[0] {
+		case "pkg-config":
+			m.PkgConfig[fields[1]] = fields[2]
+		case "include":
+			m.Includes[strings.TrimSuffix(fields[1], "/")] = fields[2]
+		default:
+			return CDepsMap{}, fmt.Errorf("line %d: unknown kind %q; want pkg-config or include", lineno, fields[0])
+		}
+	}
+	if err := s.Err(); err != nil {
+		return CDepsMap{}, err
+	}
+	return m, nil
+}
+
+// WithCDeps lets Generator add labels in "m" to cdeps of cgo packages.
+func WithCDeps(m CDepsMap) Option {
+	return func(g *generator) {
+		g.cdeps = m
+	}
+}
+
+// WithWarnf sets a function which Generator reports warnings with.
+// By default, Generator reports warnings with log.Printf.
+func WithWarnf(warnf func(format string, args ...interface{})) Option {
+	return func(g *generator) {
+		g.warnf = warnf
+	}
+}
+
+// includeRe matches "#include" directives in C/C++ files and preambles of
+// cgo files.
+var includeRe = regexp.MustCompile(`^\s*#\s*include\s*[<"]([^>"]+)[>"]`)
+
+// cDependencies returns labels of C/C++ rules which the cgo package "pkg"
+// in "dir" depends on.
+// It reports pkg-config packages not found in g.cdeps, but only once for each
+// name.
+func (g *generator) cDependencies(dir string, pkg *build.Package) ([]string, error) {
+	if !usesCgo(pkg) {
+		return nil, nil
+	}
+
+	labels := make(map[string]bool)
+	for _, name := range pkg.CgoPkgConfig {
+		if strings.HasPrefix(name, "-") {
+			continue
+		}
+		if l, ok := g.cdeps.PkgConfig[name]; ok {
+			labels[l] = true
+			continue
+		}
+		g.warnOnce("pkg-config "+name, "%s: no label known for pkg-config package %q", dir, name)
+	}
+
+	if len(g.cdeps.Includes) > 0 {
+		var files []string
+		for _, list := range [][]string{pkg.CgoFiles, pkg.CFiles, pkg.CXXFiles, pkg.HFiles} {
+			files = append(files, list...)
+		}
+		for _, f := range files {
+			fname := filepath.Join(pkg.Dir, f)
+			read := ioutil.ReadFile
+			if strings.HasSuffix(f, ".go") {
+				read = cgoPreamble
+			}
+			buf, err := read(fname)
+			if err != nil {
+				return nil, err
+			}
+			incs := includes(buf)
+			for _, inc := range incs {
+				if l, ok := g.includeLabel(inc); ok {
+					labels[l] = true
+				}
+			}
+		}
+	}
+
+	var result []string
+	for l := range labels {
+		result = append(result, l)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// includeLabel returns the label in g.cdeps which provides the header "inc".
+func (g *generator) includeLabel(inc string) (string, bool) {
+	var key string
+	for k := range g.cdeps.Includes {
+		if len(k) > len(key) && hasPathPrefix(inc, k) {
+			key = k
+		}
+	}
+	if key == "" {
+		return "", false
+	}
+	return g.cdeps.Includes[key], true
+}
+
+// includes returns paths in "#include" directives in C/C++ source "src".
+func includes(src []byte) []string {
+	var incs []string
+	for _, line := range strings.Split(string(src), "\n") {
+		if m := includeRe.FindStringSubmatch(line); m != nil {
+			incs = append(incs, m[1])
+		}
+	}
+	return incs
+}
+
+// cgoPreamble returns the preambles of `import "C"` in the cgo file "fname",
+// i.e. the doc comments of the imports, in the same way as the go tool.
+func cgoPreamble(fname string) ([]byte, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), fname, nil, parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for _, decl := range f.Decls {
+		d, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, spec := range d.Specs {
+			s, ok := spec.(*ast.ImportSpec)
+			if !ok || s.Path.Value != `"C"` {
+				continue
+			}
+			cg := s.Doc
+			if cg == nil && len(d.Specs) == 1 {
+				cg = d.Doc
+			}
+			if cg != nil {
+				buf.WriteString(cg.Text())
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,261 @@
+package generator
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A Naming is a convention of names of rules generated for Go packages.
+type Naming string
+
+const (
+	// DefaultNaming names libraries "go_default_library" and tests
+	// "go_default_test".
+	DefaultNaming = Naming("go_default_library")
+	// ImportNaming names libraries after the last element of their
+	// importpaths, and tests after their libraries. It is effective only in
+	// StructuredMode.
+	ImportNaming = Naming("import")
+)
+
+// A Directive is a "# gazel:<key> <value>" comment in a BUILD file.
+type Directive struct {
+	Key, Value string
+}
+
+// ParseDirectives extracts directives from the content of a BUILD file.
+func ParseDirectives(data []byte) []Directive {
+	var ds []Directive
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if !strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "#"))
+		if !strings.HasPrefix(line, "gazel:") {
+			continue
+		}
+		kv := strings.SplitN(strings.TrimPrefix(line, "gazel:"), " ", 2)
+		d := Directive{Key: kv[0]}
+		if len(kv) == 2 {
+			d.Value = strings.TrimSpace(kv[1])
+		}
+		ds = append(ds, d)
+	}
+	return ds
+}
+
+// ReadDirectives returns directives in the BUILD file in "dir".
+// It returns nil if there is no BUILD file.
+func ReadDirectives(dir string) ([]Directive, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "BUILD"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseDirectives(buf), nil
+}
+
+// Config is a configuration of generation for a directory.
+// Directives in the BUILD file in the directory override the configuration
+// inherited from the parent directory.
+type Config struct {
+	// Prefix is the importpath of the directory set by "gazel:prefix".
+	// Subdirectories have importpaths under it unless they set their own
+	// prefixes. It is empty if the directory does not set a prefix.
+	Prefix string
+	// Ignore is true if the directory has "gazel:ignore". Rules are not
+	// generated for the directory, but its subdirectories are still visited.
+	Ignore bool
+	// Exclude is the set of slash-delimited paths of files and directories
+	// relative to the directory, listed by "gazel:exclude". They are
+	// invisible to Generator.
+	Exclude map[string]bool
+	// BuildTags is the list of additional build tags set by
+	// "gazel:build_tags". Subdirectories inherit it.
+	BuildTags []string
+	// Naming is the naming convention set by "gazel:naming".
+	// Subdirectories inherit it.
+	Naming Naming
+}
+
+// Inherit returns the configuration of a subdirectory of the directory of
+// "c", which has directives "ds".
+// A nil Config is the default configuration.
+func (c *Config) Inherit(ds []Directive) (*Config, error) {
+	child := &Config{Naming: DefaultNaming}
+	if c != nil {
+		child.BuildTags = c.BuildTags
+		child.Naming = c.Naming
+	}
+	for _, d := range ds {
+		switch d.Key {
+		case "prefix":
+			child.Prefix = d.Value
+		case "ignore":
+			child.Ignore = true
+		case "exclude":
+			if d.Value == "" {
+				return nil, fmt.Errorf("gazel:exclude requires a file name")
+			}
+			if child.Exclude == nil {
+				child.Exclude = make(map[string]bool)
+			}
+			child.Exclude[d.Value] = true
+		case "build_tags":
+			child.BuildTags = nil
+			for _, tag := range strings.Split(d.Value, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					child.BuildTags = append(child.BuildTags, tag)
+				}
+			}
+		case "naming":
+			switch n := Naming(d.Value); n {
+			case DefaultNaming, ImportNaming:
+				child.Naming = n
+			default:
+				return nil, fmt.Errorf("unknown naming convention %q; want %q or %q", d.Value, DefaultNaming, ImportNaming)
+			}
+		default:
+			return nil, fmt.Errorf("unknown directive gazel:%s", d.Key)
+		}
+	}
+	return child, nil
+}
+
+// LoadConfig returns the configuration of the directory "dir" whose parent
+// directory has the configuration "parent".
+// Paths excluded by "parent" under "dir" are excluded by the returned one.
+func LoadConfig(dir string, parent *Config) (*Config, error) {
+	ds, err := ReadDirectives(dir)
+	if err != nil {
+		return nil, err
+	}
+	c, err := parent.Inherit(ds)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Join(dir, "BUILD"), err)
+	}
+	if parent != nil {
+		prefix := filepath.Base(dir) + "/"
+		for p := range parent.Exclude {
+			if strings.HasPrefix(p, prefix) {
+				if c.Exclude == nil {
+					c.Exclude = make(map[string]bool)
+				}
+				c.Exclude[strings.TrimPrefix(p, prefix)] = true
+			}
+		}
+	}
+	return c, nil
+}
+
+// Context returns a build context which evaluates Go packages in the
+// directory of "c" on the top of "bctx".
+// The returned context evaluates build constraints by ConstraintContext with
+// the build tags in "c", so "bctx" must not be the one returned by
+// ConstraintContext.
+func (c *Config) Context(bctx build.Context) build.Context {
+	if c == nil {
+		return ConstraintContext(bctx)
+	}
+	if len(c.BuildTags) > 0 {
+		bctx.BuildTags = append(append([]string(nil), bctx.BuildTags...), c.BuildTags...)
+	}
+	if len(c.Exclude) > 0 {
+		readDir := bctx.ReadDir
+		if readDir == nil {
+			readDir = ioutil.ReadDir
+		}
+		bctx.ReadDir = func(dir string) ([]os.FileInfo, error) {
+			infos, err := readDir(dir)
+			if err != nil {
+				return nil, err
+			}
+			var result []os.FileInfo
+			for _, info := range infos {
+				if !c.Exclude[info.Name()] {
+					result = append(result, info)
+				}
+			}
+			return result, nil
+		}
+	}
+	return ConstraintContext(bctx)
+}
+
+// naming returns the naming convention in "c".
+func (c *Config) naming() Naming {
+	if c == nil || c.Naming == "" {
+		return DefaultNaming
+	}
+	return c.Naming
+}
+
+// walkConfigs walks through directories under "root", whose configuration
+// is "c", and calls back "f" with the configuration of each directory.
+// It skips subdirectories excluded by their parents.
+func walkConfigs(root string, c *Config, f func(path string, c *Config) error) error {
+	configs := make(map[string]*Config)
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+
+		dc := c
+		if path != root {
+			parent := configs[filepath.Dir(path)]
+			if parent != nil && parent.Exclude[info.Name()] {
+				return filepath.SkipDir
+			}
+			if dc, err = LoadConfig(path, parent); err != nil {
+				return err
+			}
+		}
+		configs[path] = dc
+		return f(path, dc)
+	})
+}
+
+// ScanConfigs returns the configurations of all the directories under
+// "base", keyed by relative slash-delimited paths from "base".
+// "c" is the configuration of "base", e.g. the one returned by LoadConfig.
+func ScanConfigs(base string, c *Config) (map[string]*Config, error) {
+	configs := make(map[string]*Config)
+	err := walkConfigs(base, c, func(path string, c *Config) error {
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			rel = ""
+		}
+		configs[filepath.ToSlash(rel)] = c
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+// WithConfigs tells Generator the configurations of directories in the
+// repository, e.g. the ones returned by ScanConfigs. Generator takes
+// prefixes and naming conventions in them into account when it resolves
+// dependencies.
+func WithConfigs(configs map[string]*Config) Option {
+	return func(g *generator) {
+		g.configs = configs
+	}
+}
@@ -0,0 +1,71 @@
+package generator_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/yugui/gazel/generator"
+)
+
+func TestParseDirectives(t *testing.T) {
+	ds := generator.ParseDirectives([]byte(`
+# gazel:prefix example.com/repo
+#gazel:ignore
+# A comment which is not a directive.
+go_library(name = "gazel:naming")
+# gazel:build_tags  foo,bar
+`))
+	want := []generator.Directive{
+		{Key: "prefix", Value: "example.com/repo"},
+		{Key: "ignore"},
+		{Key: "build_tags", Value: "foo,bar"},
+	}
+	if !reflect.DeepEqual(ds, want) {
+		t.Errorf("generator.ParseDirectives(...) = %#v; want %#v", ds, want)
+	}
+}
+
+func TestConfigInherit(t *testing.T) {
+	parent, err := (*generator.Config)(nil).Inherit([]generator.Directive{
+		{Key: "prefix", Value: "example.com/repo"},
+		{Key: "ignore"},
+		{Key: "exclude", Value: "foo.go"},
+		{Key: "build_tags", Value: "foo, bar"},
+		{Key: "naming", Value: "import"},
+	})
+	if err != nil {
+		t.Fatalf("Inherit(...) failed with %v; want success", err)
+	}
+	want := &generator.Config{
+		Prefix:    "example.com/repo",
+		Ignore:    true,
+		Exclude:   map[string]bool{"foo.go": true},
+		BuildTags: []string{"foo", "bar"},
+		Naming:    generator.ImportNaming,
+	}
+	if !reflect.DeepEqual(parent, want) {
+		t.Errorf("Inherit(...) = %#v; want %#v", parent, want)
+	}
+
+	child, err := parent.Inherit(nil)
+	if err != nil {
+		t.Fatalf("parent.Inherit(nil) failed with %v; want success", err)
+	}
+	want = &generator.Config{
+		BuildTags: []string{"foo", "bar"},
+		Naming:    generator.ImportNaming,
+	}
+	if !reflect.DeepEqual(child, want) {
+		t.Errorf("parent.Inherit(nil) = %#v; want %#v", child, want)
+	}
+
+	for _, d := range []generator.Directive{
+		{Key: "unknown"},
+		{Key: "exclude"},
+		{Key: "naming", Value: "camel"},
+	} {
+		if _, err := parent.Inherit([]generator.Directive{d}); err == nil {
+			t.Errorf("parent.Inherit(%#v) succeeded; want failure", []generator.Directive{d})
+		}
+	}
+}
@@ -0,0 +1,458 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConstraintContext returns a copy of "bctx" which evaluates build
+// constraints in source files by itself instead of by go/build.
+// It understands both "//go:build" lines and "// +build" lines regardless of
+// the version of go/build gazel is built with.
+//
+// Modify the returned context only through fields irrelevant to build
+// constraints. Call ConstraintContext again for a context with other tags.
+func ConstraintContext(bctx build.Context) build.Context {
+	open := bctx.OpenFile
+	if open == nil {
+		open = func(path string) (io.ReadCloser, error) {
+			return os.Open(path)
+		}
+	}
+	tags := newTagSet(bctx)
+
+	bctx.OpenFile = func(path string) (io.ReadCloser, error) {
+		r, err := open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		buf, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+
+		c, err := parseConstraints(buf)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if !tags.matchFileName(filepath.Base(path)) || !c.eval(tags) {
+			return ioutil.NopCloser(strings.NewReader(excludedFile)), nil
+		}
+		return ioutil.NopCloser(bytes.NewReader(c.stripped)), nil
+	}
+	return bctx
+}
+
+// excludedFile is a content of a source file which go/build always excludes.
+const excludedFile = "// +build gazel_excluded,!gazel_excluded\n\npackage excluded\n"
+
+// constraints is a set of build constraints in a source file.
+type constraints struct {
+	// goBuild is the expression in the "//go:build" line, or nil if absent.
+	goBuild constraintExpr
+	// plusBuild is a list of expressions in "// +build" lines.
+	plusBuild []constraintExpr
+	// lines is the list of the constraint lines in effect, i.e. the
+	// "//go:build" line if present, or "// +build" lines otherwise.
+	lines []string
+	// stripped is the content of the file whose constraint lines are blanked.
+	// It has the same number of lines as the original.
+	stripped []byte
+}
+
+// eval determines if the file satisfies the constraints under "tags".
+// "//go:build" takes precedence over "// +build" as go/build does.
+func (c constraints) eval(tags tagSet) bool {
+	if c.goBuild != nil {
+		return c.goBuild.eval(tags)
+	}
+	for _, x := range c.plusBuild {
+		if !x.eval(tags) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseConstraints parses build constraints in the header of a source file.
+func parseConstraints(content []byte) (constraints, error) {
+	var c constraints
+	lines := bytes.Split(content, []byte("\n"))
+
+	// The header consists of comments and blank lines before the package
+	// clause. "// +build" lines must be followed by a blank line.
+	// Constraints are read only from line comments, and block comments are
+	// skipped as go/build does.
+	header, lastBlank := 0, -1
+	lineComment := make(map[int]bool)
+	inBlock := false
+	for ; header < len(lines); header++ {
+		line := bytes.TrimSpace(lines[header])
+		if inBlock {
+			i := bytes.Index(line, []byte("*/"))
+			if i < 0 {
+				continue
+			}
+			inBlock = false
+			if line = bytes.TrimSpace(line[i+2:]); len(line) == 0 {
+				continue
+			}
+		}
+		if len(line) == 0 {
+			lastBlank = header
+			continue
+		}
+		if bytes.HasPrefix(line, []byte("/*")) {
+			i := bytes.Index(line[2:], []byte("*/"))
+			if i < 0 {
+				inBlock = true
+				continue
+			}
+			if len(bytes.TrimSpace(line[2+i+2:])) == 0 {
+				continue
+			}
+			break
+		}
+		if !bytes.HasPrefix(line, []byte("//")) {
+			break
+		}
+		lineComment[header] = true
+	}
+
+	blank := make(map[int]bool)
+	for i := 0; i < header; i++ {
+		if !lineComment[i] {
+			continue
+		}
+		line := string(bytes.TrimSpace(lines[i]))
+		switch {
+		case isGoBuild(line):
+			x, err := parseGoBuild(strings.TrimPrefix(line, "//go:build"))
+			if err != nil {
+				return c, fmt.Errorf("line %d: %v", i+1, err)
+			}
+			if c.goBuild != nil {
+				return c, fmt.Errorf("line %d: multiple //go:build lines", i+1)
+			}
+			c.goBuild = x
+			c.lines = []string{line}
+			blank[i] = true
+		case strings.HasPrefix(line, "//") && i < lastBlank:
+			text := strings.TrimSpace(strings.TrimPrefix(line, "//"))
+			if !strings.HasPrefix(text, "+build") {
+				continue
+			}
+			c.plusBuild = append(c.plusBuild, parsePlusBuild(strings.TrimPrefix(text, "+build")))
+			if c.goBuild == nil {
+				c.lines = append(c.lines, line)
+			}
+			blank[i] = true
+		}
+	}
+
+	for i := range lines {
+		if blank[i] {
+			lines[i] = nil
+		}
+	}
+	c.stripped = bytes.Join(lines, []byte("\n"))
+	return c, nil
+}
+
+// isGoBuild returns true if "line" is a "//go:build" line.
+func isGoBuild(line string) bool {
+	if !strings.HasPrefix(line, "//go:build") {
+		return false
+	}
+	rest := strings.TrimPrefix(line, "//go:build")
+	return rest == "" || rest[0] == ' ' || rest[0] == '\t'
+}
+
+// A constraintExpr is a boolean expression of build tags.
+type constraintExpr interface {
+	eval(tags tagSet) bool
+}
+
+type tagExpr string
+
+func (x tagExpr) eval(tags tagSet) bool { return tags.match(string(x)) }
+
+type notExpr struct{ x constraintExpr }
+
+func (x notExpr) eval(tags tagSet) bool { return !x.x.eval(tags) }
+
+type andExpr struct{ x, y constraintExpr }
+
+func (x andExpr) eval(tags tagSet) bool { return x.x.eval(tags) && x.y.eval(tags) }
+
+type orExpr struct{ x, y constraintExpr }
+
+func (x orExpr) eval(tags tagSet) bool { return x.x.eval(tags) || x.y.eval(tags) }
+
+// parsePlusBuild parses the arguments of a "// +build" line.
+// Space-separated options are ORed, and comma-separated terms in an option
+// are ANDed.
+func parsePlusBuild(args string) constraintExpr {
+	var x constraintExpr
+	for _, opt := range strings.Fields(args) {
+		var y constraintExpr
+		for _, term := range strings.Split(opt, ",") {
+			var z constraintExpr
+			if strings.HasPrefix(term, "!") {
+				z = notExpr{tagExpr(strings.TrimPrefix(term, "!"))}
+			} else {
+				z = tagExpr(term)
+			}
+			if y == nil {
+				y = z
+			} else {
+				y = andExpr{y, z}
+			}
+		}
+		if x == nil {
+			x = y
+		} else {
+			x = orExpr{x, y}
+		}
+	}
+	if x == nil {
+		// An empty "// +build" line is never satisfied.
+		return notExpr{tagExpr("")}
+	}
+	return x
+}
+
+// parseGoBuild parses the expression in a "//go:build" line.
+func parseGoBuild(expr string) (constraintExpr, error) {
+	p := &exprParser{s: expr}
+	x, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.next(); tok != "" {
+		return nil, fmt.Errorf("unexpected token %q in //go:build", tok)
+	}
+	return x, nil
+}
+
+// exprParser is a recursive descent parser of "//go:build" expressions.
+type exprParser struct {
+	s   string
+	tok string
+}
+
+// next consumes a token and returns it. It returns an empty string at the end.
+func (p *exprParser) next() string {
+	if p.tok != "" {
+		tok := p.tok
+		p.tok = ""
+		return tok
+	}
+	p.s = strings.TrimLeft(p.s, " \t")
+	if p.s == "" {
+		return ""
+	}
+	for _, op := range []string{"&&", "||", "!", "(", ")"} {
+		if strings.HasPrefix(p.s, op) {
+			p.s = p.s[len(op):]
+			return op
+		}
+	}
+	i := strings.IndexFunc(p.s, func(r rune) bool {
+		return !isTagChar(r)
+	})
+	if i < 0 {
+		i = len(p.s)
+	}
+	if i == 0 {
+		tok := p.s[:1]
+		p.s = p.s[1:]
+		return tok
+	}
+	tok := p.s[:i]
+	p.s = p.s[i:]
+	return tok
+}
+
+// peek returns the next token without consuming it.
+func (p *exprParser) peek() string {
+	if p.tok == "" {
+		p.tok = p.next()
+	}
+	return p.tok
+}
+
+func (p *exprParser) or() (constraintExpr, error) {
+	x, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.next()
+		y, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		x = orExpr{x, y}
+	}
+	return x, nil
+}
+
+func (p *exprParser) and() (constraintExpr, error) {
+	x, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.next()
+		y, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		x = andExpr{x, y}
+	}
+	return x, nil
+}
+
+func (p *exprParser) not() (constraintExpr, error) {
+	switch tok := p.next(); tok {
+	case "!":
+		x, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{x}, nil
+	case "(":
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if tok := p.next(); tok != ")" {
+			return nil, fmt.Errorf("missing ) in //go:build")
+		}
+		return x, nil
+	case "":
+		return nil, fmt.Errorf("unexpected end of //go:build")
+	default:
+		if !isTag(tok) {
+			return nil, fmt.Errorf("unexpected token %q in //go:build", tok)
+		}
+		return tagExpr(tok), nil
+	}
+}
+
+func isTag(s string) bool {
+	for _, r := range s {
+		if !isTagChar(r) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func isTagChar(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '_' || r == '.'
+}
+
+// knownOS is the set of GOOS values which can appear in file names.
+var knownOS = map[string]bool{
+	"aix": true, "android": true, "darwin": true, "dragonfly": true,
+	"freebsd": true, "hurd": true, "illumos": true, "ios": true, "js": true,
+	"linux": true, "nacl": true, "netbsd": true, "openbsd": true,
+	"plan9": true, "solaris": true, "wasip1": true, "windows": true,
+	"zos": true,
+}
+
+// unixOS is the set of GOOS values which satisfy the "unix" tag.
+var unixOS = map[string]bool{
+	"aix": true, "android": true, "darwin": true, "dragonfly": true,
+	"freebsd": true, "hurd": true, "illumos": true, "ios": true,
+	"linux": true, "netbsd": true, "openbsd": true, "solaris": true,
+}
+
+// knownArch is the set of GOARCH values which can appear in file names.
+var knownArch = map[string]bool{
+	"386": true, "amd64": true, "amd64p32": true, "arm": true, "armbe": true,
+	"arm64": true, "arm64be": true, "loong64": true, "mips": true,
+	"mipsle": true, "mips64": true, "mips64le": true, "mips64p32": true,
+	"mips64p32le": true, "ppc": true, "ppc64": true, "ppc64le": true,
+	"riscv": true, "riscv64": true, "s390": true, "s390x": true,
+	"sparc": true, "sparc64": true, "wasm": true,
+}
+
+// tagSet is a set of build tags satisfied in a build context.
+type tagSet map[string]bool
+
+func newTagSet(bctx build.Context) tagSet {
+	tags := tagSet{
+		bctx.GOOS:     true,
+		bctx.GOARCH:   true,
+		bctx.Compiler: true,
+	}
+	if bctx.CgoEnabled {
+		tags["cgo"] = true
+	}
+	if unixOS[bctx.GOOS] {
+		tags["unix"] = true
+	}
+	switch bctx.GOOS {
+	case "android":
+		tags["linux"] = true
+	case "illumos":
+		tags["solaris"] = true
+	case "ios":
+		tags["darwin"] = true
+	}
+	for _, t := range bctx.BuildTags {
+		tags[t] = true
+	}
+	for _, t := range bctx.ReleaseTags {
+		tags[t] = true
+	}
+	return tags
+}
+
+func (s tagSet) match(tag string) bool {
+	return s[tag]
+}
+
+// matchFileName determines if a file named "name" satisfies the implicit
+// constraints by its _GOOS, _GOARCH or _GOOS_GOARCH suffix.
+func (s tagSet) matchFileName(name string) bool {
+	for _, tag := range fileNameTags(name) {
+		if !s.match(tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// fileNameTags returns the tags which a file named "name" requires by its
+// _GOOS, _GOARCH or _GOOS_GOARCH suffix.
+func fileNameTags(name string) []string {
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[:i]
+	}
+	name = strings.TrimSuffix(name, "_test")
+	// The first segment is not a suffix even if it looks like a GOOS.
+	i := strings.Index(name, "_")
+	if i < 0 {
+		return nil
+	}
+	segs := strings.Split(name[i:], "_")
+	n := len(segs)
+	if n >= 2 && knownOS[segs[n-2]] && knownArch[segs[n-1]] {
+		return segs[n-2:]
+	}
+	if n >= 1 && (knownOS[segs[n-1]] || knownArch[segs[n-1]]) {
+		return segs[n-1:]
+	}
+	return nil
+}
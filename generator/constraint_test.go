@@ -0,0 +1,144 @@
+package generator
+
+import (
+	"go/build"
+	"testing"
+)
+
+func TestParseGoBuild(t *testing.T) {
+	tags := tagSet{"linux": true, "amd64": true, "go1.9": true}
+	for _, spec := range []struct {
+		expr string
+		want bool
+	}{
+		{expr: "linux", want: true},
+		{expr: "!linux", want: false},
+		{expr: "linux && amd64", want: true},
+		{expr: "linux && !amd64", want: false},
+		{expr: "darwin || linux", want: true},
+		{expr: "darwin || windows", want: false},
+		{expr: "!(darwin || windows) && go1.9", want: true},
+		{expr: "darwin || linux && !go1.9", want: false},
+		{expr: "!!linux", want: true},
+	} {
+		x, err := parseGoBuild(spec.expr)
+		if err != nil {
+			t.Errorf("parseGoBuild(%q) failed with %v; want success", spec.expr, err)
+			continue
+		}
+		if got := x.eval(tags); got != spec.want {
+			t.Errorf("parseGoBuild(%q).eval(%v) = %t; want %t", spec.expr, tags, got, spec.want)
+		}
+	}
+}
+
+func TestParseGoBuildError(t *testing.T) {
+	for _, expr := range []string{"", "linux &&", "(linux", "linux)", "linux darwin", "linux,darwin"} {
+		if _, err := parseGoBuild(expr); err == nil {
+			t.Errorf("parseGoBuild(%q) succeeded; want error", expr)
+		}
+	}
+}
+
+func TestParseConstraints(t *testing.T) {
+	tags := tagSet{"linux": true, "amd64": true}
+	for _, spec := range []struct {
+		content  string
+		want     bool
+		stripped string
+	}{
+		{
+			content:  "package a\n",
+			want:     true,
+			stripped: "package a\n",
+		},
+		{
+			content:  "// +build linux darwin\n// +build !arm\n\npackage a\n",
+			want:     true,
+			stripped: "\n\n\npackage a\n",
+		},
+		{
+			content:  "// +build darwin\n\npackage a\n",
+			want:     false,
+			stripped: "\n\npackage a\n",
+		},
+		{
+			content:  "//go:build linux\n// +build darwin\n\npackage a\n",
+			want:     true,
+			stripped: "\n\n\npackage a\n",
+		},
+		{
+			// "// +build" not followed by a blank line is ignored.
+			content:  "// +build darwin\npackage a\n",
+			want:     true,
+			stripped: "// +build darwin\npackage a\n",
+		},
+		{
+			// Constraints after the package clause are ignored.
+			content:  "package a\n\n// +build darwin\n\n",
+			want:     true,
+			stripped: "package a\n\n// +build darwin\n\n",
+		},
+		{
+			// Block comments in the header are skipped.
+			content:  "/*\n * License\n */\n\n//go:build darwin\n\npackage a\n",
+			want:     false,
+			stripped: "/*\n * License\n */\n\n\n\npackage a\n",
+		},
+		{
+			content:  "/* License */\n// +build darwin\n\npackage a\n",
+			want:     false,
+			stripped: "/* License */\n\n\npackage a\n",
+		},
+		{
+			// Line comments in block comments are not constraints.
+			content:  "/*\n// +build darwin\n\n*/\n\npackage a\n",
+			want:     true,
+			stripped: "/*\n// +build darwin\n\n*/\n\npackage a\n",
+		},
+		{
+			// "//go:build" must be followed by a space.
+			content:  "//go:buildfoo darwin\n\npackage a\n",
+			want:     true,
+			stripped: "//go:buildfoo darwin\n\npackage a\n",
+		},
+		{
+			content:  "//go:build\tdarwin\n\npackage a\n",
+			want:     false,
+			stripped: "\n\npackage a\n",
+		},
+	} {
+		c, err := parseConstraints([]byte(spec.content))
+		if err != nil {
+			t.Errorf("parseConstraints(%q) failed with %v; want success", spec.content, err)
+			continue
+		}
+		if got := c.eval(tags); got != spec.want {
+			t.Errorf("parseConstraints(%q).eval(%v) = %t; want %t", spec.content, tags, got, spec.want)
+		}
+		if got := string(c.stripped); got != spec.stripped {
+			t.Errorf("parseConstraints(%q).stripped = %q; want %q", spec.content, got, spec.stripped)
+		}
+	}
+}
+
+func TestMatchFileName(t *testing.T) {
+	tags := newTagSet(build.Context{GOOS: "android", GOARCH: "arm64", Compiler: "gc"})
+	for _, spec := range []struct {
+		name string
+		want bool
+	}{
+		{name: "foo.go", want: true},
+		{name: "linux.go", want: true},
+		{name: "foo_linux.go", want: true},
+		{name: "foo_android_arm64.go", want: true},
+		{name: "foo_linux_amd64.go", want: false},
+		{name: "foo_windows.go", want: false},
+		{name: "foo_amd64_test.go", want: false},
+		{name: "foo_unknown.go", want: true},
+	} {
+		if got := tags.matchFileName(spec.name); got != spec.want {
+			t.Errorf("matchFileName(%q) = %t; want %t", spec.name, got, spec.want)
+		}
+	}
+}
@@ -43,8 +43,20 @@ func newRule(kind string, args []interface{}, kwargs []keyvalue) (*bzl.Rule, err
 
 // newValue converts a Go value into the corresponding expression in Bazel BUILD file.
 func newValue(val interface{}) (bzl.Expr, error) {
+	if s, ok := val.(selectStrings); ok {
+		return newSelect(s)
+	}
+	if s, ok := val.(selectString); ok {
+		return newSelectString(s), nil
+	}
+
 	rv := reflect.ValueOf(val)
 	switch rv.Kind() {
+	case reflect.Bool:
+		if rv.Bool() {
+			return &bzl.LiteralExpr{Token: "True"}, nil
+		}
+		return &bzl.LiteralExpr{Token: "False"}, nil
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
 		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 		return &bzl.LiteralExpr{Token: fmt.Sprintf("%d", val)}, nil
@@ -66,3 +78,68 @@ func newValue(val interface{}) (bzl.Expr, error) {
 		return nil, fmt.Errorf("not implemented %T", val)
 	}
 }
+
+// newSelectString converts "s" into a string expression, or a select()
+// expression if "s" varies with conditions.
+func newSelectString(s selectString) bzl.Expr {
+	deflt := &bzl.StringExpr{Value: s.deflt}
+	if len(s.cases) == 0 {
+		return deflt
+	}
+	dict := new(bzl.DictExpr)
+	for _, c := range s.cases {
+		dict.List = append(dict.List, &bzl.KeyValueExpr{
+			Key:   &bzl.StringExpr{Value: c.cond},
+			Value: &bzl.StringExpr{Value: c.values[0]},
+		})
+	}
+	dict.List = append(dict.List, &bzl.KeyValueExpr{
+		Key:   &bzl.StringExpr{Value: "//conditions:default"},
+		Value: deflt,
+	})
+	return &bzl.CallExpr{
+		X:    &bzl.LiteralExpr{Token: "select"},
+		List: []bzl.Expr{dict},
+	}
+}
+
+// newSelect converts "s" into a list expression, which can be concatenated
+// with a select() expression.
+func newSelect(s selectStrings) (bzl.Expr, error) {
+	common, err := newValue(s.common)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.cases) == 0 {
+		return common, nil
+	}
+
+	dict := new(bzl.DictExpr)
+	for _, c := range s.cases {
+		values, err := newValue(c.values)
+		if err != nil {
+			return nil, err
+		}
+		dict.List = append(dict.List, &bzl.KeyValueExpr{
+			Key:   &bzl.StringExpr{Value: c.cond},
+			Value: values,
+		})
+	}
+	deflt, err := newValue(s.deflt)
+	if err != nil {
+		return nil, err
+	}
+	dict.List = append(dict.List, &bzl.KeyValueExpr{
+		Key:   &bzl.StringExpr{Value: "//conditions:default"},
+		Value: deflt,
+	})
+	sel := &bzl.CallExpr{
+		X:    &bzl.LiteralExpr{Token: "select"},
+		List: []bzl.Expr{dict},
+	}
+
+	if len(s.common) == 0 {
+		return sel, nil
+	}
+	return &bzl.BinaryExpr{X: common, Op: "+", Y: sel}, nil
+}
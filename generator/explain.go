@@ -0,0 +1,187 @@
+package generator
+
+import (
+	"fmt"
+	"go/build"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Resolvers which Generator resolves importpaths with.
+const (
+	// KnownResolver resolves importpaths given by WithKnownImports.
+	KnownResolver = "known"
+	// FlatResolver resolves importpaths in the repository in FlatMode.
+	FlatResolver = "flat"
+	// StructuredResolver resolves importpaths in the repository in
+	// StructuredMode.
+	StructuredResolver = "structured"
+	// ExternalResolver resolves importpaths out of the repository given by
+	// WithExternalModules or WithRepoRootRules.
+	ExternalResolver = "external"
+)
+
+// An Explanation tells why rules generated for a Go package have their
+// sources and dependencies.
+type Explanation struct {
+	// Srcs explains source files of the package, sorted by their names.
+	Srcs []SourceExplanation
+	// Deps explains non-standard imports of the package, sorted by their
+	// importpaths.
+	Deps []DepExplanation
+}
+
+// A SourceExplanation tells why a source file is selected.
+type SourceExplanation struct {
+	// File is the name of the file.
+	File string
+	// Constraint describes the build constraints of the file, i.e. its
+	// constraint lines and its _GOOS or _GOARCH suffix. It is empty if the
+	// file has no constraints.
+	Constraint string
+	// Conditions is the list of conditions of variants which select the
+	// file. It is empty if the file is selected by default.
+	Conditions []string
+}
+
+// A DepExplanation tells why a dependency is added.
+type DepExplanation struct {
+	// Importpath is the imported path.
+	Importpath string
+	// Label is the label which the importpath is resolved into.
+	Label string
+	// Resolver is the one of the resolvers, e.g. StructuredResolver, which
+	// resolved the importpath.
+	Resolver string
+	// Positions is the list of the import declarations of the importpath.
+	Positions []token.Position
+	// Conditions is the list of conditions of variants which import the
+	// importpath. It is empty if it is imported by default.
+	Conditions []string
+}
+
+func (g *generator) Explain(dir string, pkg *build.Package, c *Config) (*Explanation, error) {
+	vpkgs, err := g.importVariants(pkg, c)
+	if err != nil {
+		return nil, err
+	}
+
+	srcs := make(map[string]*SourceExplanation)
+	deps := make(map[string]*DepExplanation)
+	seen := make(map[token.Position]bool)
+	// inDefault is the set of explanations of sources and imports in the
+	// default configuration, which is evaluated before variants.
+	inDefault := make(map[interface{}]bool)
+	for i, p := range append([]*build.Package{pkg}, vpkgs...) {
+		if p == nil {
+			continue
+		}
+		var cond string
+		if i > 0 {
+			cond = g.variants[i-1].Condition
+		}
+
+		for _, files := range [][]string{librarySrcs(p), p.TestGoFiles, p.XTestGoFiles} {
+			for _, f := range files {
+				s, ok := srcs[f]
+				if !ok {
+					constraint, err := fileConstraint(filepath.Join(pkg.Dir, f))
+					if err != nil {
+						return nil, err
+					}
+					s = &SourceExplanation{File: f, Constraint: constraint}
+					srcs[f] = s
+				}
+				if cond != "" && !inDefault[s] {
+					s.Conditions = append(s.Conditions, cond)
+				}
+				inDefault[s] = inDefault[s] || cond == ""
+			}
+		}
+
+		for _, pos := range []map[string][]token.Position{p.ImportPos, p.TestImportPos, p.XTestImportPos} {
+			for imp, ps := range pos {
+				d, ok := deps[imp]
+				if !ok {
+					l, err := g.Dependency(dir, imp)
+					if err != nil {
+						return nil, err
+					}
+					if l == "" {
+						continue
+					}
+					d = &DepExplanation{Importpath: imp, Label: l, Resolver: g.resolverOf(imp)}
+					deps[imp] = d
+				}
+				if cond != "" && !inDefault[d] {
+					d.Conditions = append(d.Conditions, cond)
+				}
+				inDefault[d] = inDefault[d] || cond == ""
+				for _, p := range ps {
+					if !seen[p] {
+						seen[p] = true
+						d.Positions = append(d.Positions, p)
+					}
+				}
+			}
+		}
+	}
+
+	e := new(Explanation)
+	for _, s := range srcs {
+		e.Srcs = append(e.Srcs, *s)
+	}
+	sort.Slice(e.Srcs, func(i, j int) bool { return e.Srcs[i].File < e.Srcs[j].File })
+	for _, d := range deps {
+		sort.Slice(d.Positions, func(i, j int) bool {
+			x, y := d.Positions[i], d.Positions[j]
+			if x.Filename != y.Filename {
+				return x.Filename < y.Filename
+			}
+			return x.Line < y.Line
+		})
+		e.Deps = append(e.Deps, *d)
+	}
+	sort.Slice(e.Deps, func(i, j int) bool { return e.Deps[i].Importpath < e.Deps[j].Importpath })
+	return e, nil
+}
+
+// resolverOf returns the resolver which resolves "importpath".
+func (g *generator) resolverOf(importpath string) string {
+	if _, ok := g.known[importpath]; ok {
+		return KnownResolver
+	}
+	switch {
+	case g.isExternal(importpath):
+		return ExternalResolver
+	case g.mode == FlatMode:
+		return FlatResolver
+	default:
+		return StructuredResolver
+	}
+}
+
+// fileConstraint describes the build constraints of the source file "path".
+// Files generated by gazel, which do not exist, have no constraints.
+func fileConstraint(path string) (string, error) {
+	var desc []string
+	if tags := fileNameTags(filepath.Base(path)); len(tags) > 0 {
+		desc = append(desc, "file name suffix _"+strings.Join(tags, "_"))
+	}
+	if strings.HasSuffix(path, ".go") {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		c, err := parseConstraints(buf)
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", path, err)
+		}
+		desc = append(desc, c.lines...)
+	}
+	return strings.Join(desc, "; "), nil
+}
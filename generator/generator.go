@@ -3,8 +3,11 @@ package generator
 import (
 	"fmt"
 	"go/build"
+	"log"
 	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 
 	bzl "github.com/bazelbuild/buildifier/core"
@@ -16,7 +19,15 @@ type Generator interface {
 	// "dir" is a relative path from the repository root to the directory of
 	// the Go package.
 	// "pkg" is a description about the package.
-	Generate(dir string, pkg *build.Package) ([]*bzl.Rule, error)
+	// "c" is the configuration of the directory, or nil for the default one.
+	Generate(dir string, pkg *build.Package, c *Config) ([]*bzl.Rule, error)
+	// Dependency returns the label which rules for the Go package in "dir"
+	// depend on for "importpath", as in the rules Generate generates.
+	// It returns an empty string for standard packages.
+	Dependency(dir, importpath string) (string, error)
+	// Explain tells why rules which Generate generates for the same
+	// arguments have their sources and dependencies.
+	Explain(dir string, pkg *build.Package, c *Config) (*Explanation, error)
 }
 
 // A Mode describes how Generator organizes rules for different Go packages.
@@ -34,45 +45,239 @@ const (
 // New returns an implementation of Generator.
 // "goPrefix" is the go_prefix corresponding to the repository root.
 // "mode" specifies how to organize rules for different Go packages.
-func New(goPrefix string, mode Mode) Generator {
+func New(goPrefix string, mode Mode, opts ...Option) Generator {
+	g := &generator{
+		goPrefix: goPrefix,
+		mode:     mode,
+		warnf:    log.Printf,
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	for rel, c := range g.configs {
+		if c.Prefix == "" {
+			continue
+		}
+		if rel == "" {
+			g.goPrefix, goPrefix = c.Prefix, c.Prefix
+			continue
+		}
+		g.nested = append(g.nested, Module{Path: c.Prefix, Dir: rel})
+	}
+
 	switch mode {
 	case FlatMode:
-		return &generator{
-			goPrefix: goPrefix,
-			r:        flatResolver{goPrefix: goPrefix},
-		}
+		g.r = flatResolver{goPrefix: goPrefix, nested: g.nested}
 	case StructuredMode:
-		return &generator{
-			goPrefix: goPrefix,
-			r:        structuredResolver{goPrefix: goPrefix},
-		}
+		g.r = structuredResolver{goPrefix: goPrefix, nested: g.nested}
 	default:
 		panic(fmt.Sprintf("unrecognized mode %d", mode))
 	}
+	return g
+}
+
+// An Option customizes Generator returned by New.
+type Option func(g *generator)
+
+// WithModules tells Generator about Go modules nested in the repository.
+// Packages under the directory of a nested module have importpaths under
+// the module path instead of goPrefix.
+func WithModules(nested []Module) Option {
+	return func(g *generator) {
+		g.nested = nested
+	}
+}
+
+// WithExternalModules lets Generator resolve importpaths out of the
+// repository into labels in external repositories.
+// "modules" is a list of module paths of required modules, and "modCache"
+// is a path to the local module cache or an empty string. They are used to
+// determine the root of the repository which provides an importpath.
+// Generator falls back to RepoRoot if they don't tell the root.
+func WithExternalModules(modules []string, modCache string) Option {
+	return func(g *generator) {
+		e := g.external()
+		e.modules = append(e.modules, modules...)
+		e.modCache = modCache
+	}
+}
+
+// WithRepoRootRules lets Generator resolve importpaths out of the repository
+// into labels in external repositories, like WithExternalModules.
+// "rules" are passed to RepoRoot to determine the root of the repository.
+func WithRepoRootRules(rules []*regexp.Regexp) Option {
+	return func(g *generator) {
+		e := g.external()
+		e.rules = append(e.rules, rules...)
+	}
+}
+
+// WithStdPackages tells Generator the set of importpaths of standard
+// packages, e.g. the one returned by StdPackages.
+// By default, Generator assumes the latest release of Go.
+func WithStdPackages(std map[string]bool) Option {
+	return func(g *generator) {
+		g.std = std
+	}
+}
+
+// WithKnownImports tells Generator labels of Go packages which it cannot
+// resolve by itself. "imports" maps importpaths into labels, and takes
+// precedence over the other ways to resolve importpaths.
+func WithKnownImports(imports map[string]string) Option {
+	return func(g *generator) {
+		g.known = imports
+	}
 }
 
 type generator struct {
 	goPrefix string
+	nested   []Module
+	mode     Mode
 	r        labelResolver
+	// std is the set of importpaths of standard packages. It is nil if
+	// Generator assumes the latest release of Go.
+	std map[string]bool
+	// e resolves importpaths out of the repository. It is nil if not
+	// available.
+	e *externalResolver
+	// variants is a list of alternative build configurations.
+	variants []Variant
+	// cdeps maps C/C++ dependencies of cgo packages into labels.
+	cdeps CDepsMap
+	warnf func(format string, args ...interface{})
+	// configs maps directories in the repository to their configurations.
+	configs map[string]*Config
+	// known maps importpaths into labels given by WithKnownImports.
+	known map[string]string
+	// warned is the set of warnings which have already been reported.
+	warned map[string]bool
+	// swigTool is the label of the SWIG binary, or empty to run "swig" in
+	// PATH.
+	swigTool string
+	// goarch is the target architecture, or empty for build.Default.GOARCH.
+	goarch string
+}
+
+// warnOnce reports a warning with g.warnf unless a warning for "key" has
+// already been reported.
+func (g *generator) warnOnce(key, format string, args ...interface{}) {
+	if g.warned == nil {
+		g.warned = make(map[string]bool)
+	}
+	if !g.warned[key] {
+		g.warned[key] = true
+		g.warnf(format, args...)
+	}
 }
 
-func (g *generator) Generate(dir string, pkg *build.Package) ([]*bzl.Rule, error) {
-	r, err := g.generate(filepath.Base(pkg.Dir), dir, pkg.GoFiles, pkg.Imports, pkg.IsCommand())
+// external returns g.e, initializing it if necessary.
+func (g *generator) external() *externalResolver {
+	if g.e == nil {
+		g.e = new(externalResolver)
+	}
+	return g.e
+}
+
+func (g *generator) Generate(dir string, pkg *build.Package, c *Config) ([]*bzl.Rule, error) {
+	vpkgs, err := g.importVariants(pkg, c)
 	if err != nil {
 		return nil, err
 	}
-	rules := []*bzl.Rule{r}
+	files := func(f func(p *build.Package) []string) selectStrings {
+		return g.varying(pkg, vpkgs, f)
+	}
+	deps := func(f func(p *build.Package) []string) (selectStrings, error) {
+		return g.varying(pkg, vpkgs, f).mapStrings(func(imports []string) ([]string, error) {
+			return g.dependencies(imports, dir)
+		})
+	}
+
+	var rules []*bzl.Rule
+	var library string
+	if srcs := files(librarySrcs); !srcs.empty() {
+		d, err := deps(imports)
+		if err != nil {
+			return nil, err
+		}
+		swig, err := g.generateSwig(dir, pkg, vpkgs)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, swig...)
+		if g.mode == FlatMode && dir != "" {
+			// Outputs of the genrules are relative to the top level.
+			outs := make(map[string]bool)
+			for _, p := range append([]*build.Package{pkg}, vpkgs...) {
+				if p != nil {
+					for _, f := range swigOutputs(p) {
+						outs[f] = true
+					}
+				}
+			}
+			srcs, _ = srcs.mapStrings(func(files []string) ([]string, error) {
+				var result []string
+				for _, f := range files {
+					if outs[f] {
+						f = path.Join(dir, f)
+					}
+					result = append(result, f)
+				}
+				sort.Strings(result)
+				return result, nil
+			})
+		}
+
+		cgo := usesCgo(pkg)
+		for _, p := range vpkgs {
+			cgo = cgo || p != nil && usesCgo(p)
+		}
+		cdeps := make(map[*build.Package][]string)
+		for _, p := range append([]*build.Package{pkg}, vpkgs...) {
+			if p == nil {
+				continue
+			}
+			if cdeps[p], err = g.cDependencies(dir, p); err != nil {
+				return nil, err
+			}
+		}
+		cd := files(func(p *build.Package) []string { return cdeps[p] })
+		r, err := g.generate(filepath.Base(pkg.Dir), dir, c, srcs, cd, d, pkg.IsCommand(), cgo)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+		library = r.AttrString("name")
+	}
+
+	// An external test of a package without non-test files can depend on
+	// nothing but a library which does not exist. The go tool builds it
+	// against the internal test files, but Bazel cannot compile them in a
+	// single rule, so it is skipped.
+	xtest := !files(xtestGoFiles).empty()
+	if self := g.importpath(dir); xtest && library == "" && importsSelf(self, pkg.XTestImports) {
+		g.warnf("%s: external test imports the package %q without non-test files; skipping it", dir, self)
+		xtest = false
+	}
 
-	if len(pkg.TestGoFiles) > 0 {
-		t, err := g.generateTest(dir, pkg.TestGoFiles, pkg.TestImports, r.AttrString("name"))
+	if srcs := files(testGoFiles); !srcs.empty() {
+		d, err := deps(testImports)
+		if err != nil {
+			return nil, err
+		}
+		t, err := g.generateTest(dir, c, srcs, d, library)
 		if err != nil {
 			return nil, err
 		}
 		rules = append(rules, t)
 	}
 
-	if len(pkg.XTestGoFiles) > 0 {
-		t, err := g.generateXTest(dir, pkg.XTestGoFiles, pkg.XTestImports)
+	if xtest {
+		d, err := deps(xtestImports)
+		if err != nil {
+			return nil, err
+		}
+		t, err := g.generateXTest(dir, c, files(xtestGoFiles), d)
 		if err != nil {
 			return nil, err
 		}
@@ -82,8 +287,31 @@ func (g *generator) Generate(dir string, pkg *build.Package) ([]*bzl.Rule, error
 	return rules, nil
 }
 
-func (g *generator) generate(basename, rel string, srcs, imports []string, isCommand bool) (*bzl.Rule, error) {
-	l, err := g.r.resolve(path.Join(g.goPrefix, rel), rel)
+// librarySrcs returns source files of the library or the binary in "pkg".
+// Assembly, header and syso files are always included since the go tool
+// builds them without cgo, but C and C++ files are included only if "pkg"
+// uses cgo. Files generated by SWIG replace SWIG interface files.
+func librarySrcs(pkg *build.Package) []string {
+	lists := [][]string{pkg.GoFiles, pkg.SFiles, pkg.HFiles, pkg.SysoFiles}
+	if usesCgo(pkg) {
+		lists = append(lists, pkg.CgoFiles, pkg.CFiles, pkg.CXXFiles, swigOutputs(pkg))
+	}
+	var srcs []string
+	for _, files := range lists {
+		srcs = append(srcs, files...)
+	}
+	sort.Strings(srcs)
+	return srcs
+}
+
+func imports(pkg *build.Package) []string      { return pkg.Imports }
+func testGoFiles(pkg *build.Package) []string  { return pkg.TestGoFiles }
+func testImports(pkg *build.Package) []string  { return pkg.TestImports }
+func xtestGoFiles(pkg *build.Package) []string { return pkg.XTestGoFiles }
+func xtestImports(pkg *build.Package) []string { return pkg.XTestImports }
+
+func (g *generator) generate(basename, rel string, c *Config, srcs, cdeps, deps selectStrings, isCommand, cgo bool) (*bzl.Rule, error) {
+	l, err := g.label(rel, c)
 	if err != nil {
 		return nil, err
 	}
@@ -92,27 +320,38 @@ func (g *generator) generate(basename, rel string, srcs, imports []string, isCom
 	kind := "go_library"
 	if isCommand {
 		kind = "go_binary"
-		name = basename
+		// Binaries in FlatMode are named after their paths so that they
+		// don't collide with each other.
+		if g.mode != FlatMode || rel == "" {
+			name = basename
+		}
 	}
 
 	attrs := []keyvalue{
 		{key: "name", value: name},
 		{key: "srcs", value: srcs},
 	}
-
-	deps, err := g.dependencies(imports, rel)
-	if err != nil {
-		return nil, err
+	if p := g.importpath(rel); !isCommand && p != g.defaultImportpath(rel, name) {
+		attrs = append(attrs, keyvalue{key: "importpath", value: p})
+	}
+	if cgo {
+		attrs = append(attrs, keyvalue{key: "cgo", value: true})
+	}
+	if !cdeps.empty() {
+		attrs = append(attrs, keyvalue{key: "cdeps", value: cdeps})
+	}
+	if !isCommand {
+		attrs = append(attrs, keyvalue{key: "visibility", value: g.visibility(rel)})
 	}
-	if len(deps) > 0 {
+	if !deps.empty() {
 		attrs = append(attrs, keyvalue{key: "deps", value: deps})
 	}
 
 	return newRule(kind, nil, attrs)
 }
 
-func (g *generator) generateTest(dir string, srcs, imports []string, library string) (*bzl.Rule, error) {
-	l, err := g.r.resolve(path.Join(g.goPrefix, dir), dir)
+func (g *generator) generateTest(dir string, c *Config, srcs, deps selectStrings, library string) (*bzl.Rule, error) {
+	l, err := g.label(dir, c)
 	if err != nil {
 		return nil, err
 	}
@@ -124,21 +363,18 @@ func (g *generator) generateTest(dir string, srcs, imports []string, library str
 	attrs := []keyvalue{
 		{key: "name", value: name},
 		{key: "srcs", value: srcs},
-		{key: "library", value: ":" + library},
 	}
-
-	deps, err := g.dependencies(imports, dir)
-	if err != nil {
-		return nil, err
+	if library != "" {
+		attrs = append(attrs, keyvalue{key: "library", value: ":" + library})
 	}
-	if len(deps) > 0 {
+	if !deps.empty() {
 		attrs = append(attrs, keyvalue{key: "deps", value: deps})
 	}
 	return newRule("go_test", nil, attrs)
 }
 
-func (g *generator) generateXTest(dir string, srcs, imports []string) (*bzl.Rule, error) {
-	l, err := g.r.resolve(path.Join(g.goPrefix, dir), dir)
+func (g *generator) generateXTest(dir string, c *Config, srcs, deps selectStrings) (*bzl.Rule, error) {
+	l, err := g.label(dir, c)
 	if err != nil {
 		return nil, err
 	}
@@ -150,33 +386,126 @@ func (g *generator) generateXTest(dir string, srcs, imports []string) (*bzl.Rule
 	attrs := []keyvalue{
 		{key: "name", value: name},
 		{key: "srcs", value: srcs},
+		{key: "deps", value: deps},
+	}
+	return newRule("go_test", nil, attrs)
+}
+
+// importpath returns the importpath of the Go package in the directory "rel".
+func (g *generator) importpath(rel string) string {
+	return importpathOf(g.goPrefix, g.nested, rel)
+}
+
+// defaultImportpath returns the importpath which rules_go derives from
+// go_prefix for the library named "name" generated for the directory "rel".
+func (g *generator) defaultImportpath(rel, name string) string {
+	p := strings.TrimSuffix(g.goPrefix, "/")
+	if g.mode == StructuredMode && rel != "" {
+		p += "/" + rel
 	}
+	if name != "go_default_library" {
+		p += "/" + name
+	}
+	return p
+}
 
-	deps, err := g.dependencies(imports, dir)
+// label returns the label of the library in the directory "rel" whose
+// configuration is "c".
+func (g *generator) label(rel string, c *Config) (label, error) {
+	l, err := g.r.resolve(g.importpath(rel), rel)
 	if err != nil {
-		return nil, err
+		return label{}, err
 	}
-	attrs = append(attrs, keyvalue{key: "deps", value: deps})
-	return newRule("go_test", nil, attrs)
+	return g.named(l, rel, c), nil
+}
+
+// named renames the label "l" of the library in the directory "rel" after
+// the naming convention in "c".
+func (g *generator) named(l label, rel string, c *Config) label {
+	if g.mode == StructuredMode && c.naming() == ImportNaming {
+		l.name = path.Base(g.importpath(rel))
+	}
+	return l
+}
+
+// resolve resolves "importpath" referenced from the package in "dir" into a
+// label, taking external repositories into account.
+func (g *generator) resolve(importpath, dir string) (label, error) {
+	if g.isExternal(importpath) {
+		return g.e.resolve(importpath, dir)
+	}
+	l, err := g.r.resolve(importpath, dir)
+	if err != nil {
+		return label{}, err
+	}
+	if g.mode == StructuredMode {
+		rel := l.pkg
+		if l.relative {
+			rel = dir
+		}
+		l = g.named(l, rel, g.configs[rel])
+	}
+	return l, nil
+}
+
+// isExternal determines if "importpath" is resolved into a label in an
+// external repository.
+func (g *generator) isExternal(importpath string) bool {
+	if g.e == nil || strings.HasPrefix(importpath, "./") {
+		return false
+	}
+	_, ok := relOf(g.goPrefix, g.nested, importpath)
+	return !ok
 }
 
 func (g *generator) dependencies(imports []string, dir string) ([]string, error) {
 	var deps []string
 	for _, p := range imports {
-		if isStandard(p) {
-			continue
-		}
-		l, err := g.r.resolve(p, dir)
+		l, err := g.Dependency(dir, p)
 		if err != nil {
 			return nil, err
 		}
-		deps = append(deps, l.String())
+		if l != "" {
+			deps = append(deps, l)
+		}
 	}
 	return deps, nil
 }
 
+func (g *generator) Dependency(dir, importpath string) (string, error) {
+	if l, ok := g.known[importpath]; ok {
+		return l, nil
+	}
+	// Packages in the repository take precedence over standard ones.
+	if _, ok := relOf(g.goPrefix, g.nested, importpath); !ok && g.isStandard(importpath) {
+		return "", nil
+	}
+	g.checkInternal(importpath, dir)
+	l, err := g.resolve(importpath, dir)
+	if err != nil {
+		return "", err
+	}
+	return l.String(), nil
+}
+
+// importsSelf determines if "imports" contains "importpath" itself.
+func importsSelf(importpath string, imports []string) bool {
+	for _, p := range imports {
+		if p == importpath {
+			return true
+		}
+	}
+	return false
+}
+
 // isStandard determines if importpath points a Go standard package.
-func isStandard(importpath string) bool {
-	seg := strings.SplitN(importpath, "/", 2)[0]
-	return !strings.Contains(seg, ".")
+func (g *generator) isStandard(importpath string) bool {
+	if importpath == "C" {
+		return true
+	}
+	if g.std == nil {
+		_, ok := stdPackages[importpath]
+		return ok
+	}
+	return g.std[importpath]
 }
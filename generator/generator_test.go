@@ -1,9 +1,11 @@
 package generator_test
 
 import (
+	"fmt"
 	"go/build"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 
 	bzl "github.com/bazelbuild/buildifier/core"
@@ -45,15 +47,16 @@ func packageFromDir(t *testing.T, dir string) *build.Package {
 func TestGeneratorWithLibStructured(t *testing.T) {
 	g := generator.New("example.com/repo", generator.StructuredMode)
 	pkg := packageFromDir(t, filepath.Join(testData(), "lib"))
-	rules, err := g.Generate("lib", pkg)
+	rules, err := g.Generate("lib", pkg, nil)
 	if err != nil {
-		t.Errorf(`g.Generate("lib", %#v) failed with %v; want success`, pkg, err)
+		t.Errorf(`g.Generate("lib", %#v, nil) failed with %v; want success`, pkg, err)
 	}
 
 	want := canonicalize(t, "BUILD", `
 		go_library(
 			name = "go_default_library",
 			srcs = ["doc.go", "lib.go"],
+			visibility = ["//visibility:public"],
 			deps = ["//lib/deep:go_default_library"],
 		)
 
@@ -70,22 +73,23 @@ func TestGeneratorWithLibStructured(t *testing.T) {
 		)
 	`)
 	if got := format(rules); got != want {
-		t.Errorf(`g.Generate(".", %#v) = %s; want %s`, pkg, got, want)
+		t.Errorf(`g.Generate(".", %#v, nil) = %s; want %s`, pkg, got, want)
 	}
 }
 
 func TestGeneratorWithLibFlat(t *testing.T) {
 	g := generator.New("example.com/repo", generator.FlatMode)
 	pkg := packageFromDir(t, filepath.Join(testData(), "lib"))
-	rules, err := g.Generate("lib", pkg)
+	rules, err := g.Generate("lib", pkg, nil)
 	if err != nil {
-		t.Errorf(`g.Generate("lib", %#v) failed with %v; want success`, pkg, err)
+		t.Errorf(`g.Generate("lib", %#v, nil) failed with %v; want success`, pkg, err)
 	}
 
 	want := canonicalize(t, "lib/BUILD", `
 		go_library(
 			name = "lib",
 			srcs = ["doc.go", "lib.go"],
+			visibility = ["//visibility:public"],
 			deps = [":lib/deep"],
 		)
 
@@ -102,16 +106,16 @@ func TestGeneratorWithLibFlat(t *testing.T) {
 		)
 	`)
 	if got := format(rules); got != want {
-		t.Errorf(`g.Generate("lib", %#v) = %s; want %s`, pkg, got, want)
+		t.Errorf(`g.Generate("lib", %#v, nil) = %s; want %s`, pkg, got, want)
 	}
 }
 
 func TestGeneratorWithBinStructured(t *testing.T) {
 	g := generator.New("example.com/repo", generator.StructuredMode)
 	pkg := packageFromDir(t, filepath.Join(testData(), "bin"))
-	rules, err := g.Generate("bin", pkg)
+	rules, err := g.Generate("bin", pkg, nil)
 	if err != nil {
-		t.Errorf(`g.Generate("bin", %#v) failed with %v; want success`, pkg, err)
+		t.Errorf(`g.Generate("bin", %#v, nil) failed with %v; want success`, pkg, err)
 	}
 
 	want := canonicalize(t, "BUILD", `
@@ -122,16 +126,16 @@ func TestGeneratorWithBinStructured(t *testing.T) {
 		)
 	`)
 	if got := format(rules); got != want {
-		t.Errorf(`g.Generate("bin", %#v) = %s; want %s`, pkg, got, want)
+		t.Errorf(`g.Generate("bin", %#v, nil) = %s; want %s`, pkg, got, want)
 	}
 }
 
 func TestGeneratorWithBinFlat(t *testing.T) {
 	g := generator.New("example.com/repo", generator.FlatMode)
 	pkg := packageFromDir(t, filepath.Join(testData(), "bin"))
-	rules, err := g.Generate("bin", pkg)
+	rules, err := g.Generate("bin", pkg, nil)
 	if err != nil {
-		t.Errorf(`g.Generate("bin", %#v) failed with %v; want success`, pkg, err)
+		t.Errorf(`g.Generate("bin", %#v, nil) failed with %v; want success`, pkg, err)
 	}
 
 	want := canonicalize(t, "bin/BUILD", `
@@ -142,6 +146,716 @@ func TestGeneratorWithBinFlat(t *testing.T) {
 		)
 	`)
 	if got := format(rules); got != want {
-		t.Errorf(`g.Generate("bin", %#v) = %s; want %s`, pkg, got, want)
+		t.Errorf(`g.Generate("bin", %#v, nil) = %s; want %s`, pkg, got, want)
+	}
+}
+
+func TestGeneratorWithTestsOnlyStructured(t *testing.T) {
+	var warnings []string
+	g := generator.New("example.com/repo", generator.StructuredMode, generator.WithWarnf(func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}))
+	pkg := packageFromDir(t, filepath.Join(testData(), "tests_only"))
+	rules, err := g.Generate("tests_only", pkg, nil)
+	if err != nil {
+		t.Errorf(`g.Generate("tests_only", %#v, nil) failed with %v; want success`, pkg, err)
+	}
+
+	want := canonicalize(t, "BUILD", `
+		go_test(
+			name = "go_default_test",
+			srcs = ["integration_test.go"],
+			deps = ["//lib:go_default_library"],
+		)
+	`)
+	if got := format(rules); got != want {
+		t.Errorf(`g.Generate("tests_only", %#v, nil) = %s; want %s`, pkg, got, want)
+	}
+	wantWarnings := []string{`tests_only: external test imports the package "example.com/repo/tests_only" without non-test files; skipping it`}
+	if !reflect.DeepEqual(warnings, wantWarnings) {
+		t.Errorf("warnings = %q; want %q", warnings, wantWarnings)
+	}
+}
+
+func TestGeneratorWithSelfImportingXTestOnly(t *testing.T) {
+	var warnings []string
+	g := generator.New("example.com/repo", generator.StructuredMode, generator.WithWarnf(func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}))
+	pkg := &build.Package{
+		Dir:          filepath.Join(testData(), "xtest_only"),
+		XTestGoFiles: []string{"e2e_test.go"},
+		XTestImports: []string{"example.com/repo/xtest_only"},
+	}
+	rules, err := g.Generate("xtest_only", pkg, nil)
+	if err != nil {
+		t.Errorf(`g.Generate("xtest_only", %#v, nil) failed with %v; want success`, pkg, err)
+	}
+	if len(rules) != 0 {
+		t.Errorf(`g.Generate("xtest_only", %#v, nil) = %s; want no rules`, pkg, format(rules))
+	}
+	want := []string{`xtest_only: external test imports the package "example.com/repo/xtest_only" without non-test files; skipping it`}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings = %q; want %q", warnings, want)
+	}
+}
+
+func TestGeneratorWithXTestOnlyStructured(t *testing.T) {
+	g := generator.New("example.com/repo", generator.StructuredMode)
+	pkg := packageFromDir(t, filepath.Join(testData(), "xtest_only"))
+	rules, err := g.Generate("xtest_only", pkg, nil)
+	if err != nil {
+		t.Errorf(`g.Generate("xtest_only", %#v, nil) failed with %v; want success`, pkg, err)
+	}
+
+	want := canonicalize(t, "BUILD", `
+		go_test(
+			name = "go_default_xtest",
+			srcs = ["e2e_test.go"],
+			deps = ["//lib:go_default_library"],
+		)
+	`)
+	if got := format(rules); got != want {
+		t.Errorf(`g.Generate("xtest_only", %#v, nil) = %s; want %s`, pkg, got, want)
+	}
+}
+
+func TestGeneratorWithNestedBinFlat(t *testing.T) {
+	g := generator.New("example.com/repo", generator.FlatMode)
+	pkg := packageFromDir(t, filepath.Join(testData(), "bin"))
+	rules, err := g.Generate("cmd/bin", pkg, nil)
+	if err != nil {
+		t.Errorf(`g.Generate("cmd/bin", %#v, nil) failed with %v; want success`, pkg, err)
+	}
+
+	want := canonicalize(t, "BUILD", `
+		go_binary(
+			name = "cmd/bin",
+			srcs = ["main.go"],
+			deps = [":lib"],
+		)
+	`)
+	if got := format(rules); got != want {
+		t.Errorf(`g.Generate("cmd/bin", %#v, nil) = %s; want %s`, pkg, got, want)
+	}
+}
+
+func TestGeneratorWithExternalModules(t *testing.T) {
+	g := generator.New("example.com/repo", generator.StructuredMode,
+		generator.WithExternalModules([]string{"github.com/foo/bar"}, ""))
+	pkg := packageFromDir(t, filepath.Join(testData(), "ext"))
+	rules, err := g.Generate("ext", pkg, nil)
+	if err != nil {
+		t.Errorf(`g.Generate("ext", %#v, nil) failed with %v; want success`, pkg, err)
+	}
+
+	want := canonicalize(t, "BUILD", `
+		go_library(
+			name = "go_default_library",
+			srcs = ["ext.go"],
+			visibility = ["//visibility:public"],
+			deps = [
+				"//lib:go_default_library",
+				"@com_github_foo_bar//baz:go_default_library",
+			],
+		)
+	`)
+	if got := format(rules); got != want {
+		t.Errorf(`g.Generate("ext", %#v, nil) = %s; want %s`, pkg, got, want)
+	}
+}
+
+func TestGeneratorWithDotlessPrefix(t *testing.T) {
+	g := generator.New("mycompany/repo", generator.StructuredMode)
+	pkg := packageFromDir(t, filepath.Join(testData(), "dotless"))
+	rules, err := g.Generate("dotless", pkg, nil)
+	if err != nil {
+		t.Errorf(`g.Generate("dotless", %#v, nil) failed with %v; want success`, pkg, err)
+	}
+
+	want := canonicalize(t, "BUILD", `
+		go_library(
+			name = "go_default_library",
+			srcs = ["dotless.go"],
+			visibility = ["//visibility:public"],
+			deps = ["//lib:go_default_library"],
+		)
+	`)
+	if got := format(rules); got != want {
+		t.Errorf(`g.Generate("dotless", %#v, nil) = %s; want %s`, pkg, got, want)
+	}
+}
+
+func TestGeneratorWithVariants(t *testing.T) {
+	bctx := build.Default
+	bctx.ReleaseTags = []string{"go1.1", "go1.2", "go1.3", "go1.4", "go1.5", "go1.6", "go1.7", "go1.8"}
+	tags, err := generator.ReleaseTags("1.9")
+	if err != nil {
+		t.Fatalf(`generator.ReleaseTags("1.9") failed with %v; want success`, err)
+	}
+	vctx := bctx
+	vctx.ReleaseTags = tags
+
+	g := generator.New("example.com/repo", generator.StructuredMode, generator.WithVariants([]generator.Variant{
+		{Condition: "//config:go1.9", Context: vctx},
+	}))
+	dir := filepath.Join(testData(), "versioned")
+	pkg, err := bctx.ImportDir(dir, build.ImportComment)
+	if err != nil {
+		t.Fatalf("bctx.ImportDir(%q, build.ImportComment) failed with %v; want success", dir, err)
+	}
+	rules, err := g.Generate("versioned", pkg, nil)
+	if err != nil {
+		t.Errorf(`g.Generate("versioned", %#v, nil) failed with %v; want success`, pkg, err)
+	}
+
+	want := canonicalize(t, "BUILD", `
+		go_library(
+			name = "go_default_library",
+			srcs = ["common.go"] + select({
+				"//config:go1.9": ["new.go"],
+				"//conditions:default": ["old.go"],
+			}),
+			visibility = ["//visibility:public"],
+			deps = select({
+				"//config:go1.9": ["//lib:go_default_library"],
+				"//conditions:default": [],
+			}),
+		)
+	`)
+	if got := format(rules); got != want {
+		t.Errorf(`g.Generate("versioned", %#v, nil) = %s; want %s`, pkg, got, want)
+	}
+}
+
+func TestGeneratorWithGoBuildConstraints(t *testing.T) {
+	bctx := build.Default
+	bctx.GOOS, bctx.GOARCH = "windows", "amd64"
+	vctx := bctx
+	vctx.GOOS = "linux"
+
+	g := generator.New("example.com/repo", generator.StructuredMode, generator.WithVariants([]generator.Variant{
+		{Condition: "@io_bazel_rules_go//go/platform:linux_amd64", Context: vctx},
+	}))
+	bctx = generator.ConstraintContext(bctx)
+	dir := filepath.Join(testData(), "gobuild")
+	pkg, err := bctx.ImportDir(dir, build.ImportComment)
+	if err != nil {
+		t.Fatalf("bctx.ImportDir(%q, build.ImportComment) failed with %v; want success", dir, err)
+	}
+	rules, err := g.Generate("gobuild", pkg, nil)
+	if err != nil {
+		t.Errorf(`g.Generate("gobuild", %#v, nil) failed with %v; want success`, pkg, err)
+	}
+
+	want := canonicalize(t, "BUILD", `
+		go_library(
+			name = "go_default_library",
+			srcs = ["gobuild.go"] + select({
+				"@io_bazel_rules_go//go/platform:linux_amd64": ["new.go"],
+				"//conditions:default": ["old.go"],
+			}),
+			visibility = ["//visibility:public"],
+			deps = select({
+				"@io_bazel_rules_go//go/platform:linux_amd64": ["//lib:go_default_library"],
+				"//conditions:default": [],
+			}),
+		)
+	`)
+	if got := format(rules); got != want {
+		t.Errorf(`g.Generate("gobuild", %#v, nil) = %s; want %s`, pkg, got, want)
+	}
+}
+
+func TestGeneratorWithPureVariant(t *testing.T) {
+	bctx := build.Default
+	bctx.CgoEnabled = true
+	pure := bctx
+	pure.CgoEnabled = false
+
+	g := generator.New("example.com/repo", generator.StructuredMode, generator.WithVariants([]generator.Variant{
+		{Condition: "@io_bazel_rules_go//go/config:pure", Context: pure},
+	}))
+	bctx = generator.ConstraintContext(bctx)
+	dir := filepath.Join(testData(), "cgo")
+	pkg, err := bctx.ImportDir(dir, build.ImportComment)
+	if err != nil {
+		t.Fatalf("bctx.ImportDir(%q, build.ImportComment) failed with %v; want success", dir, err)
+	}
+	rules, err := g.Generate("cgo", pkg, nil)
+	if err != nil {
+		t.Errorf(`g.Generate("cgo", %#v, nil) failed with %v; want success`, pkg, err)
+	}
+
+	want := canonicalize(t, "BUILD", `
+		go_library(
+			name = "go_default_library",
+			srcs = ["common.go"] + select({
+				"@io_bazel_rules_go//go/config:pure": ["pure.go"],
+				"//conditions:default": [
+					"native.c",
+					"native.go",
+				],
+			}),
+			cgo = True,
+			visibility = ["//visibility:public"],
+			deps = select({
+				"@io_bazel_rules_go//go/config:pure": [],
+				"//conditions:default": ["//lib:go_default_library"],
+			}),
+		)
+	`)
+	if got := format(rules); got != want {
+		t.Errorf(`g.Generate("cgo", %#v, nil) = %s; want %s`, pkg, got, want)
+	}
+}
+
+func TestGeneratorWithCDeps(t *testing.T) {
+	var warnings []string
+	g := generator.New("example.com/repo", generator.StructuredMode,
+		generator.WithCDeps(generator.CDepsMap{
+			PkgConfig: map[string]string{"zlib": "//third_party/zlib"},
+			Includes: map[string]string{
+				"foo":   "//third_party/foo:bar",
+				"other": "//third_party/other",
+			},
+		}),
+		generator.WithWarnf(func(format string, args ...interface{}) {
+			warnings = append(warnings, fmt.Sprintf(format, args...))
+		}),
+	)
+	bctx := build.Default
+	bctx.CgoEnabled = true
+	dir := filepath.Join(testData(), "cdeps")
+	pkg, err := bctx.ImportDir(dir, build.ImportComment)
+	if err != nil {
+		t.Fatalf("bctx.ImportDir(%q, build.ImportComment) failed with %v; want success", dir, err)
+	}
+	rules, err := g.Generate("cdeps", pkg, nil)
+	if err != nil {
+		t.Errorf(`g.Generate("cdeps", %#v, nil) failed with %v; want success`, pkg, err)
+	}
+
+	want := canonicalize(t, "BUILD", `
+		go_library(
+			name = "go_default_library",
+			srcs = ["cdeps.go"],
+			cgo = True,
+			cdeps = [
+				"//third_party/foo:bar",
+				"//third_party/zlib",
+			],
+			visibility = ["//visibility:public"],
+		)
+	`)
+	if got := format(rules); got != want {
+		t.Errorf(`g.Generate("cdeps", %#v, nil) = %s; want %s`, pkg, got, want)
+	}
+	// Warnings are reported only once even for another package.
+	if _, err := g.Generate("cdeps2", pkg, nil); err != nil {
+		t.Errorf(`g.Generate("cdeps2", %#v, nil) failed with %v; want success`, pkg, err)
+	}
+	if want := []string{`cdeps: no label known for pkg-config package "libunknown"`}; !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings = %q; want %q", warnings, want)
+	}
+}
+
+func TestParseCDepsMap(t *testing.T) {
+	m, err := generator.ParseCDepsMap([]byte(`
+# C/C++ dependencies
+pkg-config zlib //third_party/zlib
+include openssl/ @openssl//:ssl
+`))
+	if err != nil {
+		t.Fatalf("generator.ParseCDepsMap(...) failed with %v; want success", err)
+	}
+	want := generator.CDepsMap{
+		PkgConfig: map[string]string{"zlib": "//third_party/zlib"},
+		Includes:  map[string]string{"openssl": "@openssl//:ssl"},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("generator.ParseCDepsMap(...) = %#v; want %#v", m, want)
+	}
+
+	if _, err := generator.ParseCDepsMap([]byte("header foo.h //foo\n")); err == nil {
+		t.Errorf("generator.ParseCDepsMap(%q) succeeded; want failure", "header foo.h //foo\n")
+	}
+}
+
+func TestGeneratorWithAssembly(t *testing.T) {
+	bctx := build.Default
+	bctx.GOOS, bctx.GOARCH = "windows", "amd64"
+
+	g := generator.New("example.com/repo", generator.StructuredMode)
+	dir := filepath.Join(testData(), "asm")
+	pkg, err := bctx.ImportDir(dir, build.ImportComment)
+	if err != nil {
+		t.Fatalf("bctx.ImportDir(%q, build.ImportComment) failed with %v; want success", dir, err)
+	}
+	rules, err := g.Generate("asm", pkg, nil)
+	if err != nil {
+		t.Errorf(`g.Generate("asm", %#v, nil) failed with %v; want success`, pkg, err)
+	}
+
+	want := canonicalize(t, "BUILD", `
+		go_library(
+			name = "go_default_library",
+			srcs = [
+				"asm.go",
+				"asm_amd64.h",
+				"asm_amd64.s",
+				"rsrc_windows_amd64.syso",
+			],
+			visibility = ["//visibility:public"],
+		)
+	`)
+	if got := format(rules); got != want {
+		t.Errorf(`g.Generate("asm", %#v, nil) = %s; want %s`, pkg, got, want)
+	}
+}
+
+func TestGeneratorWithSwig(t *testing.T) {
+	bctx := build.Default
+	bctx.CgoEnabled = true
+
+	g := generator.New("example.com/repo", generator.StructuredMode, generator.WithSwig("", "amd64"))
+	dir := filepath.Join(testData(), "swig")
+	pkg, err := bctx.ImportDir(dir, build.ImportComment)
+	if err != nil {
+		t.Fatalf("bctx.ImportDir(%q, build.ImportComment) failed with %v; want success", dir, err)
+	}
+	rules, err := g.Generate("swig", pkg, nil)
+	if err != nil {
+		t.Errorf(`g.Generate("swig", %#v, nil) failed with %v; want success`, pkg, err)
+	}
+
+	want := canonicalize(t, "BUILD", `
+		genrule(
+			name = "wrap_swig",
+			srcs = [
+				"wrap.swigcxx",
+				"wrap.h",
+			],
+			outs = [
+				"wrap_swig.go",
+				"wrap_wrap.cxx",
+			],
+			cmd = "swig -go -cgo -intgosize 64 -c++ -module wrap -outdir $(@D) -o $(location wrap_wrap.cxx) $(location wrap.swigcxx) && mv $(@D)/wrap.go $(location wrap_swig.go)",
+			tags = ["automanaged"],
+		)
+
+		go_library(
+			name = "go_default_library",
+			srcs = [
+				"swig.go",
+				"wrap.h",
+				"wrap_swig.go",
+				"wrap_wrap.cxx",
+			],
+			cgo = True,
+			visibility = ["//visibility:public"],
+		)
+	`)
+	if got := format(rules); got != want {
+		t.Errorf(`g.Generate("swig", %#v, nil) = %s; want %s`, pkg, got, want)
+	}
+}
+
+func TestGeneratorWithSwigTool(t *testing.T) {
+	bctx := build.Default
+	bctx.CgoEnabled = true
+	bctx.GOOS, bctx.GOARCH = "linux", "amd64"
+	ctx386 := bctx
+	ctx386.GOARCH = "386"
+
+	g := generator.New("example.com/repo", generator.StructuredMode,
+		generator.WithSwig("//third_party/swig", bctx.GOARCH),
+		generator.WithVariants([]generator.Variant{
+			{Condition: "@io_bazel_rules_go//go/platform:linux_386", Context: ctx386},
+		}),
+	)
+	dir := filepath.Join(testData(), "swig")
+	pkg, err := bctx.ImportDir(dir, build.ImportComment)
+	if err != nil {
+		t.Fatalf("bctx.ImportDir(%q, build.ImportComment) failed with %v; want success", dir, err)
+	}
+	rules, err := g.Generate("swig", pkg, nil)
+	if err != nil {
+		t.Errorf(`g.Generate("swig", %#v, nil) failed with %v; want success`, pkg, err)
+	}
+
+	want := canonicalize(t, "BUILD", `
+		genrule(
+			name = "wrap_swig",
+			srcs = [
+				"wrap.swigcxx",
+				"wrap.h",
+			],
+			outs = [
+				"wrap_swig.go",
+				"wrap_wrap.cxx",
+			],
+			cmd = select({
+				"@io_bazel_rules_go//go/platform:linux_386": "$(location //third_party/swig) -go -cgo -intgosize 32 -c++ -module wrap -outdir $(@D) -o $(location wrap_wrap.cxx) $(location wrap.swigcxx) && mv $(@D)/wrap.go $(location wrap_swig.go)",
+				"//conditions:default": "$(location //third_party/swig) -go -cgo -intgosize 64 -c++ -module wrap -outdir $(@D) -o $(location wrap_wrap.cxx) $(location wrap.swigcxx) && mv $(@D)/wrap.go $(location wrap_swig.go)",
+			}),
+			tools = ["//third_party/swig"],
+			tags = ["automanaged"],
+		)
+
+		go_library(
+			name = "go_default_library",
+			srcs = [
+				"swig.go",
+				"wrap.h",
+				"wrap_swig.go",
+				"wrap_wrap.cxx",
+			],
+			cgo = True,
+			visibility = ["//visibility:public"],
+		)
+	`)
+	if got := format(rules); got != want {
+		t.Errorf(`g.Generate("swig", %#v, nil) = %s; want %s`, pkg, got, want)
+	}
+}
+
+func TestGeneratorWithSwigFlat(t *testing.T) {
+	bctx := build.Default
+	bctx.CgoEnabled = true
+
+	g := generator.New("example.com/repo", generator.FlatMode, generator.WithSwig("", "amd64"))
+	dir := filepath.Join(testData(), "swig")
+	pkg, err := bctx.ImportDir(dir, build.ImportComment)
+	if err != nil {
+		t.Fatalf("bctx.ImportDir(%q, build.ImportComment) failed with %v; want success", dir, err)
+	}
+	rules, err := g.Generate("swig", pkg, nil)
+	if err != nil {
+		t.Errorf(`g.Generate("swig", %#v, nil) failed with %v; want success`, pkg, err)
+	}
+
+	want := canonicalize(t, "BUILD", `
+		genrule(
+			name = "swig/wrap_swig",
+			srcs = [
+				"swig/wrap.swigcxx",
+				"swig/wrap.h",
+			],
+			outs = [
+				"swig/wrap_swig.go",
+				"swig/wrap_wrap.cxx",
+			],
+			cmd = "swig -go -cgo -intgosize 64 -c++ -module wrap -outdir $(@D)/swig -o $(location swig/wrap_wrap.cxx) $(location swig/wrap.swigcxx) && mv $(@D)/swig/wrap.go $(location swig/wrap_swig.go)",
+			tags = ["automanaged"],
+		)
+
+		go_library(
+			name = "swig",
+			srcs = [
+				"swig.go",
+				"swig/wrap_swig.go",
+				"swig/wrap_wrap.cxx",
+				"wrap.h",
+			],
+			cgo = True,
+			visibility = ["//visibility:public"],
+		)
+	`)
+	if got := format(rules); got != want {
+		t.Errorf(`g.Generate("swig", %#v, nil) = %s; want %s`, pkg, got, want)
+	}
+}
+
+func TestGeneratorWithConfigs(t *testing.T) {
+	configs := map[string]*generator.Config{
+		"":         {Prefix: "example.com/repo", Naming: generator.DefaultNaming},
+		"lib":      {Naming: generator.ImportNaming},
+		"lib/deep": {Naming: generator.ImportNaming},
+	}
+	g := generator.New("example.com/unused", generator.StructuredMode, generator.WithConfigs(configs))
+	pkg := packageFromDir(t, filepath.Join(testData(), "lib"))
+	rules, err := g.Generate("lib", pkg, configs["lib"])
+	if err != nil {
+		t.Errorf(`g.Generate("lib", %#v, configs["lib"]) failed with %v; want success`, pkg, err)
+	}
+
+	want := canonicalize(t, "BUILD", `
+		go_library(
+			name = "lib",
+			srcs = ["doc.go", "lib.go"],
+			importpath = "example.com/repo/lib",
+			visibility = ["//visibility:public"],
+			deps = ["//lib/deep:deep"],
+		)
+
+		go_test(
+			name = "lib_test",
+			srcs = ["lib_test.go"],
+			library = ":lib",
+		)
+
+		go_test(
+			name = "lib_xtest",
+			srcs = ["lib_external_test.go"],
+			deps = [":lib"],
+		)
+	`)
+	if got := format(rules); got != want {
+		t.Errorf(`g.Generate("lib", %#v, configs["lib"]) = %s; want %s`, pkg, got, want)
+	}
+}
+
+func TestGeneratorWithKnownImports(t *testing.T) {
+	g := generator.New("example.com/repo", generator.StructuredMode, generator.WithKnownImports(map[string]string{
+		"example.com/repo/lib/deep": "//third_party/deep",
+	}))
+	pkg := packageFromDir(t, filepath.Join(testData(), "lib"))
+	rules, err := g.Generate("lib", pkg, nil)
+	if err != nil {
+		t.Errorf(`g.Generate("lib", %#v, nil) failed with %v; want success`, pkg, err)
+	}
+	if got, want := rules[0].AttrStrings("deps"), []string{"//third_party/deep"}; !reflect.DeepEqual(got, want) {
+		t.Errorf(`deps of the library = %q; want %q`, got, want)
+	}
+}
+
+func TestGeneratorWithInternalPackages(t *testing.T) {
+	for _, spec := range []struct {
+		mode       generator.Mode
+		rel        string
+		visibility []string
+	}{
+		{mode: generator.StructuredMode, rel: "lib", visibility: []string{"//visibility:public"}},
+		{mode: generator.StructuredMode, rel: "internal/lib", visibility: []string{"//:__subpackages__"}},
+		{mode: generator.StructuredMode, rel: "a/internal/b/internal/lib", visibility: []string{"//a/internal/b:__subpackages__"}},
+		{mode: generator.FlatMode, rel: "internal/lib", visibility: []string{"//:__subpackages__"}},
+		{mode: generator.FlatMode, rel: "a/internal/lib", visibility: []string{"//visibility:private"}},
+	} {
+		g := generator.New("example.com/repo", spec.mode)
+		pkg := packageFromDir(t, filepath.Join(testData(), "lib"))
+		rules, err := g.Generate(spec.rel, pkg, nil)
+		if err != nil {
+			t.Errorf("g.Generate(%q, %#v, nil) failed with %v; want success", spec.rel, pkg, err)
+			continue
+		}
+		if got := rules[0].AttrStrings("visibility"); !reflect.DeepEqual(got, spec.visibility) {
+			t.Errorf("visibility of the library at %q in mode %d = %q; want %q", spec.rel, spec.mode, got, spec.visibility)
+		}
+	}
+
+	var warnings []string
+	g := generator.New("example.com/repo", generator.StructuredMode, generator.WithWarnf(func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}))
+	pkg := packageFromDir(t, filepath.Join(testData(), "internal_user"))
+	if _, err := g.Generate("internal_user", pkg, nil); err != nil {
+		t.Errorf(`g.Generate("internal_user", %#v, nil) failed with %v; want success`, pkg, err)
+	}
+	want := []string{`internal_user: use of internal package "example.com/repo/other/internal/secret" not allowed`}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings = %q; want %q", warnings, want)
+	}
+}
+
+func TestGeneratorDependency(t *testing.T) {
+	g := generator.New("example.com/repo", generator.StructuredMode, generator.WithKnownImports(map[string]string{
+		"example.com/known": "//third_party/known",
+	}))
+	for _, spec := range []struct {
+		importpath string
+		want       string
+	}{
+		{importpath: "fmt", want: ""},
+		{importpath: "example.com/repo/lib/deep", want: "//lib/deep:go_default_library"},
+		{importpath: "example.com/known", want: "//third_party/known"},
+	} {
+		got, err := g.Dependency("lib", spec.importpath)
+		if err != nil {
+			t.Errorf("g.Dependency(%q, %q) failed with %v; want success", "lib", spec.importpath, err)
+			continue
+		}
+		if got != spec.want {
+			t.Errorf("g.Dependency(%q, %q) = %q; want %q", "lib", spec.importpath, got, spec.want)
+		}
+	}
+}
+
+func TestGeneratorExplain(t *testing.T) {
+	bctx := build.Default
+	bctx.ReleaseTags = []string{"go1.1", "go1.2", "go1.3", "go1.4", "go1.5", "go1.6", "go1.7", "go1.8"}
+	tags, err := generator.ReleaseTags("1.9")
+	if err != nil {
+		t.Fatalf(`generator.ReleaseTags("1.9") failed with %v; want success`, err)
+	}
+	vctx := bctx
+	vctx.ReleaseTags = tags
+
+	g := generator.New("example.com/repo", generator.StructuredMode, generator.WithVariants([]generator.Variant{
+		{Condition: "//config:go1.9", Context: vctx},
+	}))
+	dir := filepath.Join(testData(), "versioned")
+	pkg, err := bctx.ImportDir(dir, build.ImportComment)
+	if err != nil {
+		t.Fatalf("bctx.ImportDir(%q, build.ImportComment) failed with %v; want success", dir, err)
+	}
+	e, err := g.Explain("versioned", pkg, nil)
+	if err != nil {
+		t.Fatalf(`g.Explain("versioned", %#v, nil) failed with %v; want success`, pkg, err)
+	}
+
+	wantSrcs := []generator.SourceExplanation{
+		{File: "common.go"},
+		{File: "new.go", Constraint: "// +build go1.9", Conditions: []string{"//config:go1.9"}},
+		{File: "old.go", Constraint: "// +build !go1.9"},
+	}
+	if !reflect.DeepEqual(e.Srcs, wantSrcs) {
+		t.Errorf("e.Srcs = %#v; want %#v", e.Srcs, wantSrcs)
+	}
+
+	if len(e.Deps) != 1 {
+		t.Fatalf("e.Deps = %#v; want 1 element", e.Deps)
+	}
+	d := e.Deps[0]
+	if got, want := d.Importpath, "example.com/repo/lib"; got != want {
+		t.Errorf("d.Importpath = %q; want %q", got, want)
+	}
+	if got, want := d.Label, "//lib:go_default_library"; got != want {
+		t.Errorf("d.Label = %q; want %q", got, want)
+	}
+	if got, want := d.Resolver, generator.StructuredResolver; got != want {
+		t.Errorf("d.Resolver = %q; want %q", got, want)
+	}
+	if got, want := d.Conditions, []string{"//config:go1.9"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("d.Conditions = %q; want %q", got, want)
+	}
+	if len(d.Positions) != 1 || filepath.Base(d.Positions[0].Filename) != "new.go" || d.Positions[0].Line != 6 {
+		t.Errorf("d.Positions = %v; want new.go:6", d.Positions)
+	}
+}
+
+func TestGeneratorImportpath(t *testing.T) {
+	nested := []generator.Module{{Path: "example.com/other", Dir: "third_party/other"}}
+	for _, spec := range []struct {
+		mode generator.Mode
+		rel  string
+		want string
+	}{
+		{mode: generator.StructuredMode, rel: "lib", want: ""},
+		{mode: generator.StructuredMode, rel: "third_party/other", want: "example.com/other"},
+		{mode: generator.StructuredMode, rel: "third_party/other/lib", want: "example.com/other/lib"},
+		{mode: generator.FlatMode, rel: "lib", want: ""},
+		{mode: generator.FlatMode, rel: "third_party/other", want: "example.com/other"},
+	} {
+		g := generator.New("example.com/repo", spec.mode, generator.WithModules(nested))
+		pkg := packageFromDir(t, filepath.Join(testData(), "lib", "deep"))
+		rules, err := g.Generate(spec.rel, pkg, nil)
+		if err != nil {
+			t.Errorf("g.Generate(%q, %#v, nil) failed with %v; want success", spec.rel, pkg, err)
+			continue
+		}
+		if got := rules[0].AttrString("importpath"); got != spec.want {
+			t.Errorf("importpath of the library at %q in mode %d = %q; want %q", spec.rel, spec.mode, got, spec.want)
+		}
 	}
 }
@@ -0,0 +1,255 @@
+package generator
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+)
+
+// A Dependency is an external repository pinned to a revision by a lock file
+// of a legacy dependency management tool.
+type Dependency struct {
+	// ImportPath is the importpath corresponding to the repository root.
+	ImportPath string
+	// Revision is the revision in the version control system.
+	Revision string
+	// Remote is the URL of the repository. It is empty if the repository
+	// can be fetched from ImportPath.
+	Remote string
+	// VCS is the kind of the version control system. It is "git" if empty.
+	VCS string
+}
+
+// ParseGopkgLock parses the content of a Gopkg.lock file of dep.
+func ParseGopkgLock(data []byte) ([]Dependency, error) {
+	var (
+		deps    []Dependency
+		cur     *Dependency
+		inArray bool
+	)
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; s.Scan(); lineno++ {
+		line := strings.TrimSpace(s.Text())
+		if inArray {
+			inArray = !strings.HasSuffix(line, "]")
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			cur = nil
+			if line == "[[projects]]" {
+				deps = append(deps, Dependency{})
+				cur = &deps[len(deps)-1]
+			}
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("line %d: malformed line in Gopkg.lock", lineno)
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if strings.HasPrefix(value, "[") {
+			inArray = !strings.HasSuffix(value, "]")
+			continue
+		}
+		if cur == nil || !strings.HasPrefix(value, `"`) {
+			continue
+		}
+		str, err := strconv.Unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: malformed string %s: %v", lineno, value, err)
+		}
+		switch key {
+		case "name":
+			cur.ImportPath = str
+		case "revision":
+			cur.Revision = str
+		case "source":
+			cur.Remote = str
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return deps, nil
+}
+
+// ParseGlideLock parses the content of a glide.lock file of Glide.
+func ParseGlideLock(data []byte) ([]Dependency, error) {
+	var (
+		deps  []Dependency
+		cur   *Dependency
+		inDep bool
+	)
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; s.Scan(); lineno++ {
+		line := s.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "-") {
+			// A top level key
+			key := strings.TrimSpace(strings.SplitN(line, ":", 2)[0])
+			inDep = key == "imports" || key == "testImports"
+			cur = nil
+			continue
+		}
+		if !inDep {
+			continue
+		}
+
+		item := strings.TrimSpace(line)
+		if strings.HasPrefix(line, "- ") {
+			deps = append(deps, Dependency{})
+			cur = &deps[len(deps)-1]
+			item = strings.TrimSpace(strings.TrimPrefix(line, "- "))
+		}
+		if cur == nil {
+			return nil, fmt.Errorf("line %d: malformed line in glide.lock", lineno)
+		}
+		kv := strings.SplitN(item, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value, err := yamlScalar(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineno, err)
+		}
+		switch strings.TrimSpace(kv[0]) {
+		case "name":
+			cur.ImportPath = value
+		case "version":
+			cur.Revision = value
+		case "repo":
+			cur.Remote = value
+		case "vcs":
+			cur.VCS = value
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return deps, nil
+}
+
+// yamlScalar returns the value of a scalar in YAML.
+func yamlScalar(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("malformed string %s", s)
+		}
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	}
+	return s, nil
+}
+
+// ParseGodeps parses the content of a Godeps/Godeps.json file of godep.
+// Packages in the same repository are merged into a dependency.
+// "rules" are passed to RepoRoot to determine the roots of repositories.
+func ParseGodeps(data []byte, rules ...*regexp.Regexp) ([]Dependency, error) {
+	var godeps struct {
+		Deps []struct {
+			ImportPath, Rev string
+		}
+	}
+	if err := json.Unmarshal(data, &godeps); err != nil {
+		return nil, err
+	}
+	var deps []Dependency
+	for _, d := range godeps.Deps {
+		deps = append(deps, Dependency{ImportPath: d.ImportPath, Revision: d.Rev})
+	}
+	return mergePackages(deps, rules)
+}
+
+// ParseVendorJSON parses the content of a vendor/vendor.json file of govendor.
+// Packages in the same repository are merged into a dependency.
+// "rules" are passed to RepoRoot to determine the roots of repositories.
+func ParseVendorJSON(data []byte, rules ...*regexp.Regexp) ([]Dependency, error) {
+	var vendor struct {
+		Package []struct {
+			Path, Revision, Origin string
+		}
+	}
+	if err := json.Unmarshal(data, &vendor); err != nil {
+		return nil, err
+	}
+	var deps []Dependency
+	for _, p := range vendor.Package {
+		deps = append(deps, Dependency{ImportPath: p.Path, Revision: p.Revision})
+	}
+	return mergePackages(deps, rules)
+}
+
+// mergePackages merges dependencies on packages into dependencies on their
+// repositories.
+// It determines the roots of repositories with RepoRoot and "rules".
+// Otherwise, it assumes that a package is in the same repository as its
+// ancestor package if they are pinned to the same revision.
+// It returns an error if packages in a repository are pinned to different
+// revisions.
+func mergePackages(deps []Dependency, rules []*regexp.Regexp) ([]Dependency, error) {
+	for i, d := range deps {
+		if root, ok := RepoRoot(d.ImportPath, rules...); ok {
+			deps[i].ImportPath = root
+		}
+	}
+	sort.Slice(deps, func(i, j int) bool {
+		return deps[i].ImportPath < deps[j].ImportPath
+	})
+	var merged []Dependency
+	for _, d := range deps {
+		if n := len(merged); n > 0 {
+			last := merged[n-1]
+			if last.Revision == d.Revision && hasPathPrefix(d.ImportPath, last.ImportPath) {
+				continue
+			}
+			if last.ImportPath == d.ImportPath {
+				return nil, fmt.Errorf("repository %s is pinned to conflicting revisions %s and %s", d.ImportPath, last.Revision, d.Revision)
+			}
+		}
+		merged = append(merged, d)
+	}
+	return merged, nil
+}
+
+// GenerateLockedRepositories generates go_repository rules for "deps".
+func GenerateLockedRepositories(deps []Dependency) ([]*bzl.Rule, error) {
+	var rules []*bzl.Rule
+	for _, d := range deps {
+		attrs := []keyvalue{
+			{key: "name", value: RepositoryName(d.ImportPath)},
+			{key: "commit", value: d.Revision},
+			{key: "importpath", value: d.ImportPath},
+		}
+		if d.Remote != "" {
+			vcs := d.VCS
+			if vcs == "" {
+				vcs = "git"
+			}
+			attrs = append(attrs,
+				keyvalue{key: "remote", value: d.Remote},
+				keyvalue{key: "vcs", value: vcs},
+			)
+		}
+		r, err := newRule("go_repository", nil, attrs)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
@@ -0,0 +1,184 @@
+package generator_test
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/yugui/gazel/generator"
+)
+
+func TestParseGopkgLock(t *testing.T) {
+	content := `
+# This file is autogenerated, do not edit; changes may be undone by the next 'dep ensure'.
+
+[[projects]]
+  name = "github.com/foo/bar"
+  packages = [
+    ".",
+    "sub"
+  ]
+  revision = "0123456789abcdef"
+  version = "v1.0.0"
+
+[[projects]]
+  branch = "master"
+  name = "golang.org/x/net"
+  packages = ["context"]
+  revision = "fedcba9876543210"
+  source = "https://github.com/golang/net.git"
+
+[solve-meta]
+  analyzer-name = "dep"
+  inputs-digest = "abcdef"
+`
+	deps, err := generator.ParseGopkgLock([]byte(content))
+	if err != nil {
+		t.Fatalf("generator.ParseGopkgLock(%q) failed with %v; want success", content, err)
+	}
+	want := []generator.Dependency{
+		{ImportPath: "github.com/foo/bar", Revision: "0123456789abcdef"},
+		{ImportPath: "golang.org/x/net", Revision: "fedcba9876543210", Remote: "https://github.com/golang/net.git"},
+	}
+	if !reflect.DeepEqual(deps, want) {
+		t.Errorf("generator.ParseGopkgLock(%q) = %#v; want %#v", content, deps, want)
+	}
+}
+
+func TestParseGlideLock(t *testing.T) {
+	content := `hash: abcdef
+updated: 2017-01-01T00:00:00.000000000+09:00
+imports:
+- name: github.com/foo/bar
+  version: 0123456789abcdef
+  subpackages:
+  - sub
+- name: golang.org/x/net
+  version: fedcba9876543210
+  repo: https://github.com/golang/net
+  vcs: git
+testImports:
+- name: github.com/stretchr/testify
+  version: "1111111111111111"
+`
+	deps, err := generator.ParseGlideLock([]byte(content))
+	if err != nil {
+		t.Fatalf("generator.ParseGlideLock(%q) failed with %v; want success", content, err)
+	}
+	want := []generator.Dependency{
+		{ImportPath: "github.com/foo/bar", Revision: "0123456789abcdef"},
+		{ImportPath: "golang.org/x/net", Revision: "fedcba9876543210", Remote: "https://github.com/golang/net", VCS: "git"},
+		{ImportPath: "github.com/stretchr/testify", Revision: "1111111111111111"},
+	}
+	if !reflect.DeepEqual(deps, want) {
+		t.Errorf("generator.ParseGlideLock(%q) = %#v; want %#v", content, deps, want)
+	}
+}
+
+func TestParseGodeps(t *testing.T) {
+	content := `{
+	"ImportPath": "example.com/repo",
+	"GoVersion": "go1.8",
+	"Deps": [
+		{"ImportPath": "github.com/foo/bar", "Rev": "0123456789abcdef"},
+		{"ImportPath": "github.com/foo/bar/sub", "Rev": "0123456789abcdef"},
+		{"ImportPath": "golang.org/x/net/context", "Comment": "v0.1", "Rev": "fedcba9876543210"},
+		{"ImportPath": "example.com/foo/bar", "Rev": "1111111111111111"}
+	]
+}`
+	deps, err := generator.ParseGodeps([]byte(content))
+	if err != nil {
+		t.Fatalf("generator.ParseGodeps(%q) failed with %v; want success", content, err)
+	}
+	want := []generator.Dependency{
+		{ImportPath: "example.com/foo/bar", Revision: "1111111111111111"},
+		{ImportPath: "github.com/foo/bar", Revision: "0123456789abcdef"},
+		{ImportPath: "golang.org/x/net", Revision: "fedcba9876543210"},
+	}
+	if !reflect.DeepEqual(deps, want) {
+		t.Errorf("generator.ParseGodeps(%q) = %#v; want %#v", content, deps, want)
+	}
+}
+
+func TestParseVendorJSON(t *testing.T) {
+	content := `{
+	"comment": "",
+	"package": [
+		{"path": "github.com/foo/bar/sub", "revision": "0123456789abcdef", "revisionTime": "2017-01-01T00:00:00Z"},
+		{"path": "github.com/foo/bar", "revision": "0123456789abcdef", "revisionTime": "2017-01-01T00:00:00Z"}
+	],
+	"rootPath": "example.com/repo"
+}`
+	deps, err := generator.ParseVendorJSON([]byte(content))
+	if err != nil {
+		t.Fatalf("generator.ParseVendorJSON(%q) failed with %v; want success", content, err)
+	}
+	want := []generator.Dependency{
+		{ImportPath: "github.com/foo/bar", Revision: "0123456789abcdef"},
+	}
+	if !reflect.DeepEqual(deps, want) {
+		t.Errorf("generator.ParseVendorJSON(%q) = %#v; want %#v", content, deps, want)
+	}
+}
+
+func TestParseGodepsWithRepoRootRules(t *testing.T) {
+	content := `{
+	"Deps": [
+		{"ImportPath": "example.com/foo/bar/a", "Rev": "0123456789abcdef"},
+		{"ImportPath": "example.com/foo/bar/b", "Rev": "0123456789abcdef"}
+	]
+}`
+	rules := []*regexp.Regexp{regexp.MustCompile(`^(example\.com/[^/]+/[^/]+)(/.*)?$`)}
+	deps, err := generator.ParseGodeps([]byte(content), rules...)
+	if err != nil {
+		t.Fatalf("generator.ParseGodeps(%q, %v...) failed with %v; want success", content, rules, err)
+	}
+	want := []generator.Dependency{
+		{ImportPath: "example.com/foo/bar", Revision: "0123456789abcdef"},
+	}
+	if !reflect.DeepEqual(deps, want) {
+		t.Errorf("generator.ParseGodeps(%q, %v...) = %#v; want %#v", content, rules, deps, want)
+	}
+}
+
+func TestParseGodepsWithConflictingRevisions(t *testing.T) {
+	content := `{
+	"Deps": [
+		{"ImportPath": "github.com/foo/bar/a", "Rev": "0123456789abcdef"},
+		{"ImportPath": "github.com/foo/bar/b", "Rev": "fedcba9876543210"}
+	]
+}`
+	if deps, err := generator.ParseGodeps([]byte(content)); err == nil {
+		t.Errorf("generator.ParseGodeps(%q) = %#v; want an error", content, deps)
+	}
+}
+
+func TestGenerateLockedRepositories(t *testing.T) {
+	deps := []generator.Dependency{
+		{ImportPath: "github.com/foo/bar", Revision: "0123456789abcdef"},
+		{ImportPath: "golang.org/x/net", Revision: "fedcba9876543210", Remote: "https://github.com/golang/net"},
+	}
+	rules, err := generator.GenerateLockedRepositories(deps)
+	if err != nil {
+		t.Errorf("generator.GenerateLockedRepositories(%#v) failed with %v; want success", deps, err)
+	}
+
+	want := canonicalize(t, "WORKSPACE", `
+		go_repository(
+			name = "com_github_foo_bar",
+			commit = "0123456789abcdef",
+			importpath = "github.com/foo/bar",
+		)
+
+		go_repository(
+			name = "org_golang_x_net",
+			commit = "fedcba9876543210",
+			importpath = "golang.org/x/net",
+			remote = "https://github.com/golang/net",
+			vcs = "git",
+		)
+	`)
+	if got := format(rules); got != want {
+		t.Errorf("generator.GenerateLockedRepositories(%#v) = %s; want %s", deps, got, want)
+	}
+}
@@ -0,0 +1,295 @@
+package generator
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A Module describes a Go module nested in the current repository.
+type Module struct {
+	// Path is the module path declared in the go.mod file of the module.
+	Path string
+	// Dir is a relative slash-delimited path from the top level of the
+	// current repository to the directory containing the go.mod file.
+	Dir string
+}
+
+// A ModFile is the content of a go.mod file.
+type ModFile struct {
+	// Module is the module path declared by the module directive.
+	Module string
+	// Require is a list of modules required by the require directives.
+	Require []Requirement
+	// Replace is a list of replacements declared by the replace directives.
+	Replace []Replacement
+}
+
+// A Requirement is a version of a module required in a go.mod file.
+type Requirement struct {
+	Path, Version string
+}
+
+// A Replacement replaces a module with another module or a local directory.
+type Replacement struct {
+	// OldPath and OldVersion specify the module to be replaced.
+	// OldVersion is empty if all the versions of the module are replaced.
+	OldPath, OldVersion string
+	// Path and Version specify the replacement.
+	// Version is empty if Path is a local directory.
+	Path, Version string
+}
+
+// IsLocal determines if "r" replaces a module with a local directory.
+func (r Replacement) IsLocal() bool {
+	return r.Version == ""
+}
+
+// ParseModFile parses the content of a go.mod file.
+func ParseModFile(data []byte) (*ModFile, error) {
+	var (
+		mf    ModFile
+		block string
+	)
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; s.Scan(); lineno++ {
+		fields, err := modFields(s.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineno, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		verb := block
+		switch {
+		case block != "" && len(fields) == 1 && fields[0] == ")":
+			block = ""
+			continue
+		case block != "":
+		case len(fields) == 2 && fields[1] == "(":
+			block = fields[0]
+			continue
+		default:
+			verb, fields = fields[0], fields[1:]
+		}
+
+		switch verb {
+		case "module":
+			if len(fields) != 1 {
+				return nil, fmt.Errorf("line %d: usage: module path", lineno)
+			}
+			mf.Module = fields[0]
+		case "require":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: usage: require module/path v1.2.3", lineno)
+			}
+			mf.Require = append(mf.Require, Requirement{Path: fields[0], Version: fields[1]})
+		case "replace":
+			r, err := parseReplacement(fields)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineno, err)
+			}
+			mf.Replace = append(mf.Replace, r)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return &mf, nil
+}
+
+// modFields splits a line in a go.mod file into tokens, removing comments
+// and quotes.
+func modFields(line string) ([]string, error) {
+	if i := strings.Index(line, "//"); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	for i, f := range fields {
+		if !strings.HasPrefix(f, `"`) && !strings.HasPrefix(f, "`") {
+			continue
+		}
+		uq, err := strconv.Unquote(f)
+		if err != nil {
+			return nil, fmt.Errorf("malformed string %s: %v", f, err)
+		}
+		fields[i] = uq
+	}
+	return fields, nil
+}
+
+func parseReplacement(fields []string) (Replacement, error) {
+	var r Replacement
+	switch {
+	case len(fields) >= 3 && fields[1] == "=>":
+		r.OldPath, fields = fields[0], fields[2:]
+	case len(fields) >= 4 && fields[2] == "=>":
+		r.OldPath, r.OldVersion, fields = fields[0], fields[1], fields[3:]
+	default:
+		return r, fmt.Errorf("usage: replace module/path [v1.2.3] => other/module v1.4.5 or local/directory")
+	}
+	switch len(fields) {
+	case 1:
+		r.Path = fields[0]
+	case 2:
+		r.Path, r.Version = fields[0], fields[1]
+	default:
+		return r, fmt.Errorf("usage: replace module/path [v1.2.3] => other/module v1.4.5 or local/directory")
+	}
+	return r, nil
+}
+
+// ParseModulePath returns the module path declared in the content of a
+// go.mod file.
+func ParseModulePath(data []byte) (string, error) {
+	mf, err := ParseModFile(data)
+	if err != nil {
+		return "", err
+	}
+	if mf.Module == "" {
+		return "", fmt.Errorf("no module directive found")
+	}
+	return mf.Module, nil
+}
+
+// ParseSumFile parses the content of a go.sum file.
+// It returns a map from "path@version" to the hash of the module.
+// Hashes of go.mod files are ignored.
+func ParseSumFile(data []byte) (map[string]string, error) {
+	sums := make(map[string]string)
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; s.Scan(); lineno++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: malformed line in go.sum", lineno)
+		}
+		if strings.HasSuffix(fields[1], "/go.mod") {
+			continue
+		}
+		sums[fields[0]+"@"+fields[1]] = fields[2]
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return sums, nil
+}
+
+// ReadModulePath reads the go.mod file in "dir" and returns the module path
+// declared in it.
+func ReadModulePath(dir string) (string, error) {
+	fname := filepath.Join(dir, "go.mod")
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return "", err
+	}
+	p, err := ParseModulePath(buf)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", fname, err)
+	}
+	return p, nil
+}
+
+// FindModules returns Go modules nested in the directory "root", whose
+// configuration is "c".
+// The module at "root" itself is not included. Directories excluded by
+// "gazel:exclude", "vendor", "testdata" and the ones whose names start with
+// "." or "_" are skipped.
+func FindModules(root string, c *Config) ([]Module, error) {
+	var mods []Module
+	err := walkConfigs(root, c, func(p string, c *Config) error {
+		if p == root {
+			return nil
+		}
+		if skipModuleDir(filepath.Base(p)) {
+			return filepath.SkipDir
+		}
+		modpath, err := ReadModulePath(p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		mods = append(mods, Module{Path: modpath, Dir: filepath.ToSlash(rel)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mods, nil
+}
+
+// skipModuleDir determines if FindModules skips a directory named "name".
+func skipModuleDir(name string) bool {
+	switch name {
+	case "vendor", "testdata":
+		return true
+	}
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")
+}
+
+// moduleOf returns the module which the directory "rel" belongs to.
+// The module at the top level of the repository has the path "goPrefix".
+func moduleOf(goPrefix string, nested []Module, rel string) Module {
+	m := Module{Path: goPrefix}
+	for _, n := range nested {
+		if len(n.Dir) > len(m.Dir) && hasPathPrefix(rel, n.Dir) {
+			m = n
+		}
+	}
+	return m
+}
+
+// importpathOf returns the importpath of the Go package in the directory
+// "rel".
+func importpathOf(goPrefix string, nested []Module, rel string) string {
+	m := moduleOf(goPrefix, nested, rel)
+	return path.Join(m.Path, strings.TrimPrefix(strings.TrimPrefix(rel, m.Dir), "/"))
+}
+
+// relOf returns the directory of the Go package "importpath" and true if
+// the package belongs to a module in the current repository.
+func relOf(goPrefix string, nested []Module, importpath string) (string, bool) {
+	m := Module{Path: goPrefix}
+	found := hasPathPrefix(importpath, goPrefix)
+	for _, n := range nested {
+		if (!found || len(n.Path) > len(m.Path)) && hasPathPrefix(importpath, n.Path) {
+			m, found = n, true
+		}
+	}
+	if !found {
+		return "", false
+	}
+	rel := path.Join(m.Dir, strings.TrimPrefix(strings.TrimPrefix(importpath, m.Path), "/"))
+	if rel == "." {
+		rel = ""
+	}
+	// The directory can belong to another module nested in "m".
+	if moduleOf(goPrefix, nested, rel) != m {
+		return "", false
+	}
+	return rel, true
+}
+
+// hasPathPrefix determines if the slash-delimited path "p" is "prefix" itself
+// or is under "prefix".
+func hasPathPrefix(p, prefix string) bool {
+	if prefix == "" {
+		return true
+	}
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
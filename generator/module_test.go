@@ -0,0 +1,126 @@
+package generator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseModulePath(t *testing.T) {
+	for _, spec := range []struct {
+		content, want string
+	}{
+		{content: "module example.com/repo\n", want: "example.com/repo"},
+		{content: "// comment\nmodule example.com/repo // trailing\n\ngo 1.12\n", want: "example.com/repo"},
+		{content: `module "example.com/repo"`, want: "example.com/repo"},
+	} {
+		got, err := ParseModulePath([]byte(spec.content))
+		if err != nil {
+			t.Errorf("ParseModulePath(%q) failed with %v; want success", spec.content, err)
+			continue
+		}
+		if got != spec.want {
+			t.Errorf("ParseModulePath(%q) = %q; want %q", spec.content, got, spec.want)
+		}
+	}
+}
+
+func TestParseModulePathError(t *testing.T) {
+	for _, content := range []string{
+		"",
+		"go 1.12\n",
+		`module "example.com/repo`,
+	} {
+		if got, err := ParseModulePath([]byte(content)); err == nil {
+			t.Errorf("ParseModulePath(%q) = %q; want error", content, got)
+		}
+	}
+}
+
+func TestFindModules(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "module_test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, p := range []struct {
+		path, content string
+	}{
+		{path: "go.mod", content: "module example.com/repo"},
+		{path: "a/go.mod", content: "module example.com/a"},
+		{path: "a/b/go.mod", content: "module example.com/a/b"},
+		{path: "c/c.go", content: "package c"},
+		{path: "BUILD", content: "# gazel:exclude excluded\n"},
+		{path: "excluded/go.mod", content: "module example.com/excluded"},
+		{path: "vendor/example.com/v/go.mod", content: "module example.com/v"},
+		{path: "testdata/go.mod", content: "module example.com/testdata"},
+		{path: ".git/go.mod", content: "module example.com/git"},
+		{path: "_hidden/go.mod", content: "module example.com/hidden"},
+	} {
+		path := filepath.Join(dir, p.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("os.MkdirAll(%q, 0700) failed with %v; want success", filepath.Dir(path), err)
+		}
+		if err := ioutil.WriteFile(path, []byte(p.content), 0600); err != nil {
+			t.Fatalf("ioutil.WriteFile(%q, %q, 0600) failed with %v; want success", path, p.content, err)
+		}
+	}
+
+	c, err := LoadConfig(dir, nil)
+	if err != nil {
+		t.Fatalf("LoadConfig(%q, nil) failed with %v; want success", dir, err)
+	}
+	mods, err := FindModules(dir, c)
+	if err != nil {
+		t.Fatalf("FindModules(%q, c) failed with %v; want success", dir, err)
+	}
+	want := []Module{
+		{Path: "example.com/a", Dir: "a"},
+		{Path: "example.com/a/b", Dir: "a/b"},
+	}
+	if !reflect.DeepEqual(mods, want) {
+		t.Errorf("FindModules(%q, c) = %v; want %v", dir, mods, want)
+	}
+}
+
+func TestModulePaths(t *testing.T) {
+	nested := []Module{
+		{Path: "example.com/repo/sub", Dir: "sub"},
+		{Path: "example.com/other", Dir: "third_party/other"},
+	}
+	for _, spec := range []struct {
+		rel, importpath string
+	}{
+		{rel: "", importpath: "example.com/repo"},
+		{rel: "lib", importpath: "example.com/repo/lib"},
+		{rel: "sub", importpath: "example.com/repo/sub"},
+		{rel: "sub/lib", importpath: "example.com/repo/sub/lib"},
+		{rel: "third_party/other", importpath: "example.com/other"},
+		{rel: "third_party/other/lib", importpath: "example.com/other/lib"},
+	} {
+		if got, want := importpathOf("example.com/repo", nested, spec.rel), spec.importpath; got != want {
+			t.Errorf("importpathOf(%q) = %q; want %q", spec.rel, got, want)
+		}
+		rel, ok := relOf("example.com/repo", nested, spec.importpath)
+		if !ok {
+			t.Errorf("relOf(%q) failed; want success", spec.importpath)
+			continue
+		}
+		if got, want := rel, spec.rel; got != want {
+			t.Errorf("relOf(%q) = %q; want %q", spec.importpath, got, want)
+		}
+	}
+
+	for _, importpath := range []string{
+		"example.com/another",
+		"example.com/repo_suffix",
+		"example.com/repo/third_party/other",
+	} {
+		if rel, ok := relOf("example.com/repo", nested, importpath); ok {
+			t.Errorf("relOf(%q) = %q; want failure", importpath, rel)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package generator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ReleaseTags returns the release tags which the release "version" of Go
+// satisfies, e.g. "go1.1" to "go1.9" for "1.9".
+// They are suitable for ReleaseTags in build.Context.
+func ReleaseTags(version string) ([]string, error) {
+	minor, err := parseGoVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	for i := 1; i <= minor; i++ {
+		tags = append(tags, fmt.Sprintf("go1.%d", i))
+	}
+	return tags, nil
+}
+
+// parseGoVersion returns the minor version of the Go 1.x release "version",
+// e.g. 9 for "1.9", "go1.9" or "1.9.2".
+func parseGoVersion(version string) (int, error) {
+	segs := strings.Split(strings.TrimPrefix(version, "go"), ".")
+	if len(segs) < 2 || segs[0] != "1" {
+		return 0, fmt.Errorf("unsupported Go version %q", version)
+	}
+	minor, err := strconv.Atoi(segs[1])
+	if err != nil || minor < 0 {
+		return 0, fmt.Errorf("malformed Go version %q", version)
+	}
+	return minor, nil
+}
@@ -0,0 +1,21 @@
+package generator_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/yugui/gazel/generator"
+)
+
+func TestReleaseTags(t *testing.T) {
+	for _, version := range []string{"1.3", "go1.3", "1.3.2"} {
+		tags, err := generator.ReleaseTags(version)
+		if err != nil {
+			t.Errorf("generator.ReleaseTags(%q) failed with %v; want success", version, err)
+			continue
+		}
+		if got, want := tags, []string{"go1.1", "go1.2", "go1.3"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("generator.ReleaseTags(%q) = %q; want %q", version, got, want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package generator_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/yugui/gazel/generator"
+)
+
+func TestRepoRoot(t *testing.T) {
+	for _, spec := range []struct {
+		importpath, want string
+	}{
+		{importpath: "github.com/foo/bar", want: "github.com/foo/bar"},
+		{importpath: "github.com/foo/bar/baz/qux", want: "github.com/foo/bar"},
+		{importpath: "bitbucket.org/foo/bar/baz", want: "bitbucket.org/foo/bar"},
+		{importpath: "gopkg.in/yaml.v2", want: "gopkg.in/yaml.v2"},
+		{importpath: "gopkg.in/check.v1/sub", want: "gopkg.in/check.v1"},
+		{importpath: "gopkg.in/foo/bar.v3/baz", want: "gopkg.in/foo/bar.v3"},
+		{importpath: "golang.org/x/net/context", want: "golang.org/x/net"},
+		{importpath: "google.golang.org/grpc/codes", want: "google.golang.org/grpc"},
+		{importpath: "cloud.google.com/go/storage", want: "cloud.google.com/go"},
+	} {
+		got, ok := generator.RepoRoot(spec.importpath)
+		if !ok {
+			t.Errorf("generator.RepoRoot(%q) failed; want success", spec.importpath)
+			continue
+		}
+		if got != spec.want {
+			t.Errorf("generator.RepoRoot(%q) = %q; want %q", spec.importpath, got, spec.want)
+		}
+	}
+
+	for _, importpath := range []string{
+		"example.com/foo/bar",
+		"github.com/foo",
+		"gopkg.in/foo",
+	} {
+		if got, ok := generator.RepoRoot(importpath); ok {
+			t.Errorf("generator.RepoRoot(%q) = %q; want failure", importpath, got)
+		}
+	}
+}
+
+func TestRepoRootWithRules(t *testing.T) {
+	rules := []*regexp.Regexp{
+		regexp.MustCompile(`^(example\.com/[^/]+/[^/]+)(/|$)`),
+		regexp.MustCompile(`^git\.example\.org/[^/]+`),
+	}
+	for _, spec := range []struct {
+		importpath, want string
+	}{
+		{importpath: "example.com/foo/bar/baz", want: "example.com/foo/bar"},
+		{importpath: "git.example.org/foo/bar", want: "git.example.org/foo"},
+		{importpath: "github.com/foo/bar/baz", want: "github.com/foo/bar"},
+	} {
+		got, ok := generator.RepoRoot(spec.importpath, rules...)
+		if !ok {
+			t.Errorf("generator.RepoRoot(%q, %q...) failed; want success", spec.importpath, rules)
+			continue
+		}
+		if got != spec.want {
+			t.Errorf("generator.RepoRoot(%q, %q...) = %q; want %q", spec.importpath, rules, got, spec.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package generator
+
+import (
+	"strings"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+)
+
+// RepositoryName returns the name of the external repository in Bazel
+// which provides the Go package "importpath" at its top level.
+// e.g. "github.com/foo/bar" is mapped to "com_github_foo_bar".
+func RepositoryName(importpath string) string {
+	segs := strings.SplitN(importpath, "/", 2)
+	host := strings.Split(segs[0], ".")
+	for i, j := 0, len(host)-1; i < j; i, j = i+1, j-1 {
+		host[i], host[j] = host[j], host[i]
+	}
+	segs[0] = strings.Join(host, ".")
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', '0' <= r && r <= '9', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, strings.ToLower(strings.Join(segs, "/")))
+}
+
+// GenerateRepositories generates go_repository rules for the modules
+// required in "mf".
+// "sums" is a map from "path@version" to the hash of the module as returned
+// by ParseSumFile.
+// Modules replaced with local directories are skipped.
+func GenerateRepositories(mf *ModFile, sums map[string]string) ([]*bzl.Rule, error) {
+	var rules []*bzl.Rule
+	for _, req := range mf.Require {
+		attrs := []keyvalue{
+			{key: "name", value: RepositoryName(req.Path)},
+			{key: "importpath", value: req.Path},
+		}
+		path, version := req.Path, req.Version
+		if r, ok := replacementOf(mf, req); ok {
+			if r.IsLocal() {
+				continue
+			}
+			path, version = r.Path, r.Version
+			attrs = append(attrs, keyvalue{key: "replace", value: path})
+		}
+		if sum, ok := sums[path+"@"+version]; ok {
+			attrs = append(attrs, keyvalue{key: "sum", value: sum})
+		}
+		attrs = append(attrs, keyvalue{key: "version", value: version})
+
+		r, err := newRule("go_repository", nil, attrs)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// replacementOf returns the replacement in "mf" which applies to "req".
+func replacementOf(mf *ModFile, req Requirement) (Replacement, bool) {
+	for _, r := range mf.Replace {
+		if r.OldPath == req.Path && (r.OldVersion == "" || r.OldVersion == req.Version) {
+			return r, true
+		}
+	}
+	return Replacement{}, false
+}
@@ -0,0 +1,80 @@
+package generator_test
+
+import (
+	"testing"
+
+	"github.com/yugui/gazel/generator"
+)
+
+func TestRepositoryName(t *testing.T) {
+	for _, spec := range []struct {
+		importpath, want string
+	}{
+		{importpath: "github.com/foo/bar", want: "com_github_foo_bar"},
+		{importpath: "golang.org/x/net", want: "org_golang_x_net"},
+		{importpath: "gopkg.in/yaml.v2", want: "in_gopkg_yaml_v2"},
+		{importpath: "github.com/Foo/bar-baz", want: "com_github_foo_bar_baz"},
+	} {
+		if got, want := generator.RepositoryName(spec.importpath), spec.want; got != want {
+			t.Errorf("generator.RepositoryName(%q) = %q; want %q", spec.importpath, got, want)
+		}
+	}
+}
+
+func TestGenerateRepositories(t *testing.T) {
+	mf, err := generator.ParseModFile([]byte(`
+		module example.com/repo
+
+		require (
+			github.com/foo/bar v1.0.0
+			golang.org/x/net v0.1.0 // indirect
+			example.com/local v1.0.0
+		)
+		require gopkg.in/yaml.v2 v2.2.2
+
+		replace golang.org/x/net v0.1.0 => github.com/golang/net v0.1.1
+		replace example.com/local => ../local
+	`))
+	if err != nil {
+		t.Fatalf("generator.ParseModFile failed with %v; want success", err)
+	}
+	sums, err := generator.ParseSumFile([]byte(`
+github.com/foo/bar v1.0.0 h1:bar=
+github.com/foo/bar v1.0.0/go.mod h1:barmod=
+github.com/golang/net v0.1.1 h1:net=
+	`))
+	if err != nil {
+		t.Fatalf("generator.ParseSumFile failed with %v; want success", err)
+	}
+
+	rules, err := generator.GenerateRepositories(mf, sums)
+	if err != nil {
+		t.Errorf("generator.GenerateRepositories(%#v, %v) failed with %v; want success", mf, sums, err)
+	}
+
+	want := canonicalize(t, "WORKSPACE", `
+		go_repository(
+			name = "com_github_foo_bar",
+			importpath = "github.com/foo/bar",
+			sum = "h1:bar=",
+			version = "v1.0.0",
+		)
+
+		go_repository(
+			name = "org_golang_x_net",
+			importpath = "golang.org/x/net",
+			replace = "github.com/golang/net",
+			sum = "h1:net=",
+			version = "v0.1.1",
+		)
+
+		go_repository(
+			name = "in_gopkg_yaml_v2",
+			importpath = "gopkg.in/yaml.v2",
+			version = "v2.2.2",
+		)
+	`)
+	if got := format(rules); got != want {
+		t.Errorf("generator.GenerateRepositories(%#v, %v) = %s; want %s", mf, sums, got, want)
+	}
+}
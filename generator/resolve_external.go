@@ -0,0 +1,145 @@
+package generator
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// externalResolver resolves importpaths in external repositories into labels
+// in the repositories named after RepositoryName.
+// It determines the root of the repository by the longest prefix match
+// against modules in "modules" or those in the module cache, or by RepoRoot
+// as a last resort.
+type externalResolver struct {
+	// modules is a list of module paths of required modules.
+	modules []string
+	// modCache is a path to the local module cache. It is empty if not
+	// available.
+	modCache string
+	// rules is a list of additional rules passed to RepoRoot.
+	rules []*regexp.Regexp
+}
+
+func (r *externalResolver) resolve(importpath, dir string) (label, error) {
+	root, ok := r.root(importpath)
+	if !ok {
+		return label{}, fmt.Errorf("cannot determine the repository root of %q", importpath)
+	}
+	return label{
+		repo: RepositoryName(root),
+		pkg:  strings.TrimPrefix(strings.TrimPrefix(importpath, root), "/"),
+		name: "go_default_library",
+	}, nil
+}
+
+// root returns the module path which provides the package "importpath".
+func (r *externalResolver) root(importpath string) (string, bool) {
+	var root string
+	for _, m := range r.modules {
+		if len(m) > len(root) && hasPathPrefix(importpath, m) {
+			root = m
+		}
+	}
+	if root != "" {
+		return root, true
+	}
+	if r.modCache != "" {
+		for p := importpath; p != "." && p != "/"; p = path.Dir(p) {
+			if r.cached(p) {
+				return p, true
+			}
+		}
+	}
+	return RepoRoot(importpath, r.rules...)
+}
+
+// cached determines if any version of the module "modpath" is in the module
+// cache.
+func (r *externalResolver) cached(modpath string) bool {
+	escaped := filepath.FromSlash(escapeModulePath(modpath))
+	for _, pattern := range []string{
+		filepath.Join(r.modCache, escaped) + "@*",
+		filepath.Join(r.modCache, "cache", "download", escaped, "@v", "*.zip"),
+	} {
+		if matches, err := filepath.Glob(pattern); err == nil && len(matches) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// knownRepoRoots is a list of rules for RepoRoot about well-known hosts.
+var knownRepoRoots = []*regexp.Regexp{
+	regexp.MustCompile(`^(github\.com/[A-Za-z0-9_.\-]+/[A-Za-z0-9_.\-]+)(/|$)`),
+	regexp.MustCompile(`^(bitbucket\.org/[A-Za-z0-9_.\-]+/[A-Za-z0-9_.\-]+)(/|$)`),
+	regexp.MustCompile(`^(gopkg\.in/[A-Za-z0-9_\-]+\.v[0-9]+)(/|$)`),
+	regexp.MustCompile(`^(gopkg\.in/[A-Za-z0-9_.\-]+/[A-Za-z0-9_\-]+\.v[0-9]+)(/|$)`),
+	regexp.MustCompile(`^(golang\.org/x/[A-Za-z0-9_.\-]+)(/|$)`),
+	regexp.MustCompile(`^(google\.golang\.org/[A-Za-z0-9_.\-]+)(/|$)`),
+	regexp.MustCompile(`^(cloud\.google\.com/go)(/|$)`),
+}
+
+// RepoRoot returns the importpath corresponding to the root of the
+// repository which contains the Go package "importpath".
+// It knows how well-known hosts like github.com and gopkg.in structure
+// importpaths, without accessing network.
+// "rules" are additional rules consulted before the built-in ones. The first
+// subexpression of a rule, or the whole match if it has no subexpression,
+// must match the root.
+// It returns false if no rule matches "importpath".
+func RepoRoot(importpath string, rules ...*regexp.Regexp) (string, bool) {
+	for _, list := range [][]*regexp.Regexp{rules, knownRepoRoots} {
+		for _, re := range list {
+			m := re.FindStringSubmatch(importpath)
+			switch {
+			case m == nil:
+				continue
+			case len(m) > 1:
+				return m[1], true
+			default:
+				return m[0], true
+			}
+		}
+	}
+	return "", false
+}
+
+// escapeModulePath escapes upper case letters in "modpath" in the way the
+// module cache does. e.g. "github.com/Foo" is escaped into "github.com/!foo".
+func escapeModulePath(modpath string) string {
+	var buf []rune
+	for _, r := range modpath {
+		if unicode.IsUpper(r) {
+			buf = append(buf, '!', unicode.ToLower(r))
+			continue
+		}
+		buf = append(buf, r)
+	}
+	return string(buf)
+}
+
+// DefaultModCache returns the path to the local module cache, or an empty
+// string if it does not exist.
+func DefaultModCache() string {
+	dir := os.Getenv("GOMODCACHE")
+	if dir == "" {
+		gopath := filepath.SplitList(os.Getenv("GOPATH"))
+		if len(gopath) == 0 || gopath[0] == "" {
+			home := os.Getenv("HOME")
+			if home == "" {
+				return ""
+			}
+			gopath = []string{filepath.Join(home, "go")}
+		}
+		dir = filepath.Join(gopath[0], "pkg", "mod")
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return ""
+	}
+	return dir
+}
@@ -0,0 +1,114 @@
+package generator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestExternalResolver(t *testing.T) {
+	modCache, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "modcache")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed with %v; want success", err)
+	}
+	defer os.RemoveAll(modCache)
+	for _, dir := range []string{
+		"gopkg.in/yaml.v2@v2.2.2",
+		"github.com/!burnt!sushi/toml@v0.3.1",
+		"cache/download/go.opencensus.io/@v",
+	} {
+		if err := os.MkdirAll(filepath.Join(modCache, dir), 0700); err != nil {
+			t.Fatalf("os.MkdirAll(%q, 0700) failed with %v; want success", dir, err)
+		}
+	}
+	zip := filepath.Join(modCache, "cache/download/go.opencensus.io/@v/v0.22.0.zip")
+	if err := ioutil.WriteFile(zip, nil, 0600); err != nil {
+		t.Fatalf("ioutil.WriteFile(%q, nil, 0600) failed with %v; want success", zip, err)
+	}
+
+	r := &externalResolver{
+		modules: []string{
+			"golang.org/x/net",
+			"cloud.google.com/go",
+			"cloud.google.com/go/storage",
+		},
+		modCache: modCache,
+	}
+	for _, spec := range []struct {
+		importpath string
+		want       label
+	}{
+		{
+			importpath: "golang.org/x/net",
+			want:       label{repo: "org_golang_x_net", name: "go_default_library"},
+		},
+		{
+			importpath: "golang.org/x/net/context",
+			want:       label{repo: "org_golang_x_net", pkg: "context", name: "go_default_library"},
+		},
+		{
+			importpath: "cloud.google.com/go/pubsub",
+			want:       label{repo: "com_google_cloud_go", pkg: "pubsub", name: "go_default_library"},
+		},
+		{
+			importpath: "cloud.google.com/go/storage/internal",
+			want:       label{repo: "com_google_cloud_go_storage", pkg: "internal", name: "go_default_library"},
+		},
+		{
+			importpath: "gopkg.in/yaml.v2",
+			want:       label{repo: "in_gopkg_yaml_v2", name: "go_default_library"},
+		},
+		{
+			importpath: "github.com/BurntSushi/toml",
+			want:       label{repo: "com_github_burntsushi_toml", name: "go_default_library"},
+		},
+		{
+			importpath: "go.opencensus.io/trace/propagation",
+			want:       label{repo: "io_opencensus_go", pkg: "trace/propagation", name: "go_default_library"},
+		},
+	} {
+		l, err := r.resolve(spec.importpath, "")
+		if err != nil {
+			t.Errorf(`r.resolve(%q, "") failed with %v; want success`, spec.importpath, err)
+			continue
+		}
+		if got, want := l, spec.want; !reflect.DeepEqual(got, want) {
+			t.Errorf(`r.resolve(%q, "") = %s; want %s`, spec.importpath, got, want)
+		}
+	}
+
+	if l, err := r.resolve("example.com/unknown/pkg", ""); err == nil {
+		t.Errorf(`r.resolve("example.com/unknown/pkg", "") = %s; want error`, l)
+	}
+}
+
+func TestExternalResolverWithRepoRootRules(t *testing.T) {
+	r := &externalResolver{
+		rules: []*regexp.Regexp{regexp.MustCompile(`^example\.com/[^/]+`)},
+	}
+	for _, spec := range []struct {
+		importpath string
+		want       label
+	}{
+		{
+			importpath: "github.com/foo/bar/baz",
+			want:       label{repo: "com_github_foo_bar", pkg: "baz", name: "go_default_library"},
+		},
+		{
+			importpath: "example.com/foo/bar",
+			want:       label{repo: "com_example_foo", pkg: "bar", name: "go_default_library"},
+		},
+	} {
+		l, err := r.resolve(spec.importpath, "")
+		if err != nil {
+			t.Errorf(`r.resolve(%q, "") failed with %v; want success`, spec.importpath, err)
+			continue
+		}
+		if got, want := l, spec.want; !reflect.DeepEqual(got, want) {
+			t.Errorf(`r.resolve(%q, "") = %s; want %s`, spec.importpath, got, want)
+		}
+	}
+}
@@ -1,32 +1,76 @@
 package generator
 
 import (
+	"bytes"
 	"fmt"
 	"path"
 	"strings"
+	"unicode/utf8"
 )
 
 // flatResolver resolves go_library labels within the same repository as
 // the one of goPrefix, assuming all rules are defined in a single BUILD file.
 type flatResolver struct {
 	goPrefix string
+	// nested is a list of Go modules nested in the repository.
+	nested []Module
 }
 
 func (r flatResolver) resolve(importpath, dir string) (label, error) {
 	if strings.HasPrefix(importpath, "./") {
-		importpath = path.Join(r.goPrefix, dir, importpath[2:])
+		importpath = path.Join(importpathOf(r.goPrefix, r.nested, dir), importpath[2:])
 	}
 
-	if importpath == r.goPrefix {
-		return label{name: "go_default_library", relative: true}, nil
+	if rel, ok := relOf(r.goPrefix, r.nested, importpath); ok {
+		return label{name: flatName(rel), relative: true}, nil
 	}
 
-	if prefix := r.goPrefix + "/"; strings.HasPrefix(importpath, prefix) {
-		return label{
-			name:     strings.TrimPrefix(importpath, prefix),
-			relative: true,
-		}, nil
+	return label{}, fmt.Errorf("importpath %q does not start with goPrefix %q", importpath, r.goPrefix)
+}
+
+// flatName returns the name of the go_library rule for the Go package at
+// "rel" in FlatMode.
+// "rel" is a relative slash-delimited path from the top level of the current
+// repository.
+//
+// Names are unique to packages. Bytes which cannot appear in target names
+// and "%" itself are escaped into "%XX" in hex. The first byte of a package
+// named like a library at the top level, and the underscore of a suffix
+// which names of tests end with are escaped as well, so that names of
+// libraries never collide with the ones of other rules.
+func flatName(rel string) string {
+	if rel == "" {
+		return "go_default_library"
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(rel); i++ {
+		if c := rel[i]; c != '%' && c < utf8.RuneSelf && isLabelNameChar(rune(c)) {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buf, "%%%02x", c)
+		}
+	}
+	name := buf.String()
+	if name == "go_default_library" {
+		return "%67" + name[1:]
 	}
+	for _, suffix := range flatReservedSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix) + "%5f" + suffix[1:]
+		}
+	}
+	return name
+}
 
-	return label{}, fmt.Errorf("importpath %q does not start with goPrefix %q", importpath, r.goPrefix)
+// flatReservedSuffixes is the list of suffixes of names of rules other than
+// libraries in FlatMode.
+var flatReservedSuffixes = []string{"_test", "_xtest", "_swig"}
+
+// isLabelNameChar determines if "r" can appear in a target name in Bazel.
+func isLabelNameChar(r rune) bool {
+	switch {
+	case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("!%-@^_\"#$&'()*+,;<=>?[]{|}~/.", r)
 }
@@ -1,6 +1,7 @@
 package generator
 
 import (
+	"fmt"
 	"reflect"
 	"testing"
 )
@@ -20,6 +21,42 @@ func TestFlatResolver(t *testing.T) {
 			importpath: "example.com/repo/sub",
 			want:       label{name: "sub", relative: true},
 		},
+		{
+			importpath: "example.com/repo/sub/a:b",
+			want:       label{name: "sub/a%3ab", relative: true},
+		},
+	} {
+		l, err := r.resolve(spec.importpath, "")
+		if err != nil {
+			t.Errorf(`r.resolve(%q, "") failed with %v; want success`, spec.importpath, err)
+			continue
+		}
+		if got, want := l, spec.want; !reflect.DeepEqual(got, want) {
+			t.Errorf(`r.resolve(%q, "") = %s; want %s`, spec.importpath, got, want)
+		}
+	}
+}
+
+func TestFlatResolverWithModules(t *testing.T) {
+	r := flatResolver{
+		goPrefix: "example.com/repo",
+		nested: []Module{
+			{Path: "example.com/other", Dir: "third_party/other"},
+		},
+	}
+
+	for _, spec := range []struct {
+		importpath string
+		want       label
+	}{
+		{
+			importpath: "example.com/other",
+			want:       label{name: "third_party/other", relative: true},
+		},
+		{
+			importpath: "example.com/other/sub",
+			want:       label{name: "third_party/other/sub", relative: true},
+		},
 	} {
 		l, err := r.resolve(spec.importpath, "")
 		if err != nil {
@@ -46,3 +83,49 @@ func TestFlatResolverError(t *testing.T) {
 		}
 	}
 }
+
+func TestFlatNameSanitization(t *testing.T) {
+	for _, spec := range []struct {
+		rel, want string
+	}{
+		{rel: "", want: "go_default_library"},
+		{rel: "lib/deep", want: "lib/deep"},
+		{rel: "lib/v1.2-beta", want: "lib/v1.2-beta"},
+		{rel: "lib/日本", want: "lib/%e6%97%a5%e6%9c%ac"},
+		{rel: "lib/a:b", want: "lib/a%3ab"},
+		{rel: "lib/a_b", want: "lib/a_b"},
+		{rel: "lib/a%3ab", want: "lib/a%253ab"},
+		{rel: "go_default_library", want: "%67o_default_library"},
+		{rel: "lib/go_default_library", want: "lib/go_default_library"},
+		{rel: "lib/deep_test", want: "lib/deep%5ftest"},
+		{rel: "lib/deep_xtest", want: "lib/deep%5fxtest"},
+	} {
+		if got, want := flatName(spec.rel), spec.want; got != want {
+			t.Errorf("flatName(%q) = %q; want %q", spec.rel, got, want)
+		}
+	}
+}
+
+func TestFlatNameCollision(t *testing.T) {
+	names := make(map[string]string)
+	add := func(name, desc string) {
+		if other, ok := names[name]; ok {
+			t.Errorf("%s and %s have the same name %q", other, desc, name)
+		}
+		names[name] = desc
+	}
+	for _, rel := range []string{
+		"", "go_default_library", "go_default_test", "go_default_xtest",
+		"lib/deep", "lib/deep_test", "lib/deep_xtest",
+		"a:b", "a_b", "a%3ab",
+	} {
+		// Rules generated for a package in FlatMode.
+		add(flatName(rel), fmt.Sprintf("library of %q", rel))
+		test, xtest := flatName(rel)+"_test", flatName(rel)+"_xtest"
+		if rel == "" {
+			test, xtest = "go_default_test", "go_default_xtest"
+		}
+		add(test, fmt.Sprintf("test of %q", rel))
+		add(xtest, fmt.Sprintf("external test of %q", rel))
+	}
+}
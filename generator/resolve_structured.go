@@ -10,22 +10,19 @@ import (
 // the one of goPrefix.
 type structuredResolver struct {
 	goPrefix string
+	// nested is a list of Go modules nested in the repository.
+	nested []Module
 }
 
 // resolve takes a Go importpath within the same respository as r.goPrefix
 // and resolves it into a label in Bazel.
 func (r structuredResolver) resolve(importpath, dir string) (label, error) {
 	if strings.HasPrefix(importpath, "./") {
-		importpath = path.Join(r.goPrefix, dir, importpath[2:])
+		importpath = path.Join(importpathOf(r.goPrefix, r.nested, dir), importpath[2:])
 	}
 
-	if importpath == r.goPrefix {
-		return label{name: "go_default_library"}, nil
-	}
-
-	if prefix := r.goPrefix + "/"; strings.HasPrefix(importpath, prefix) {
-		pkg := strings.TrimPrefix(importpath, prefix)
-		if pkg == dir {
+	if pkg, ok := relOf(r.goPrefix, r.nested, importpath); ok {
+		if pkg != "" && pkg == dir {
 			return label{name: "go_default_library", relative: true}, nil
 		}
 		return label{pkg: pkg, name: "go_default_library"}, nil
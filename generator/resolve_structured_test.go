@@ -61,6 +61,50 @@ func TestStructuredResolver(t *testing.T) {
 	}
 }
 
+func TestStructuredResolverWithModules(t *testing.T) {
+	r := structuredResolver{
+		goPrefix: "example.com/repo",
+		nested: []Module{
+			{Path: "example.com/other", Dir: "third_party/other"},
+		},
+	}
+	for _, spec := range []struct {
+		importpath string
+		curPkg     string
+		want       label
+	}{
+		{
+			importpath: "example.com/other",
+			curPkg:     "lib",
+			want:       label{pkg: "third_party/other", name: "go_default_library"},
+		},
+		{
+			importpath: "example.com/other/lib",
+			curPkg:     "lib",
+			want:       label{pkg: "third_party/other/lib", name: "go_default_library"},
+		},
+		{
+			importpath: "example.com/repo/lib",
+			curPkg:     "third_party/other",
+			want:       label{pkg: "lib", name: "go_default_library"},
+		},
+		{
+			importpath: "./lib",
+			curPkg:     "third_party/other",
+			want:       label{pkg: "third_party/other/lib", name: "go_default_library"},
+		},
+	} {
+		l, err := r.resolve(spec.importpath, spec.curPkg)
+		if err != nil {
+			t.Errorf("r.resolve(%q, %q) failed with %v; want success", spec.importpath, spec.curPkg, err)
+			continue
+		}
+		if got, want := l, spec.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("r.resolve(%q, %q) = %s; want %s", spec.importpath, spec.curPkg, got, want)
+		}
+	}
+}
+
 func TestStructuredResolverError(t *testing.T) {
 	r := structuredResolver{goPrefix: "example.com/repo"}
 
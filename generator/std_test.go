@@ -0,0 +1,93 @@
+package generator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStdPackages(t *testing.T) {
+	for _, spec := range []struct {
+		version  string
+		included []string
+		excluded []string
+	}{
+		{
+			version:  "1.6",
+			included: []string{"fmt", "net/http", "go/types"},
+			excluded: []string{"context", "math/bits", "appengine"},
+		},
+		{
+			version:  "go1.7",
+			included: []string{"fmt", "context"},
+			excluded: []string{"math/bits"},
+		},
+		{
+			version:  "1.21.3",
+			included: []string{"context", "math/bits", "slices", "log/slog"},
+			excluded: []string{"iter"},
+		},
+	} {
+		std, err := StdPackages(spec.version)
+		if err != nil {
+			t.Errorf("StdPackages(%q) failed with %v; want success", spec.version, err)
+			continue
+		}
+		for _, p := range spec.included {
+			if !std[p] {
+				t.Errorf("StdPackages(%q)[%q] = false; want true", spec.version, p)
+			}
+		}
+		for _, p := range spec.excluded {
+			if std[p] {
+				t.Errorf("StdPackages(%q)[%q] = true; want false", spec.version, p)
+			}
+		}
+	}
+}
+
+func TestStdPackagesError(t *testing.T) {
+	for _, version := range []string{"", "2.0", "1", "1.x"} {
+		if _, err := StdPackages(version); err == nil {
+			t.Errorf("StdPackages(%q) succeeded; want error", version)
+		}
+	}
+}
+
+func TestStdPackagesInGOROOT(t *testing.T) {
+	goroot, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "goroot")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed with %v; want success", err)
+	}
+	defer os.RemoveAll(goroot)
+
+	for _, p := range []string{
+		"src/fmt/print.go",
+		"src/fmt/fmt_test.go",
+		"src/net/http/server.go",
+		"src/net/http/internal/chunked.go",
+		"src/net/http/testdata/main.go",
+		"src/vendor/golang.org/x/net/route/route.go",
+		"src/cmd/go/main.go",
+		"src/testing/quick/quick_test.go",
+	} {
+		path := filepath.Join(goroot, p)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("os.MkdirAll(%q, 0700) failed with %v; want success", filepath.Dir(path), err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+			t.Fatalf("ioutil.WriteFile(%q, nil, 0600) failed with %v; want success", path, err)
+		}
+	}
+
+	std, err := StdPackagesInGOROOT(goroot)
+	if err != nil {
+		t.Fatalf("StdPackagesInGOROOT(%q) failed with %v; want success", goroot, err)
+	}
+	want := map[string]bool{"fmt": true, "net/http": true}
+	if !reflect.DeepEqual(std, want) {
+		t.Errorf("StdPackagesInGOROOT(%q) = %v; want %v", goroot, std, want)
+	}
+}
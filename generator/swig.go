@@ -0,0 +1,182 @@
+package generator
+
+import (
+	"fmt"
+	"go/build"
+	"go/types"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+)
+
+// AutomanagedTag is the tag of rules other than Go rules which Generator
+// generates, e.g. genrules running SWIG. Tools updating BUILD files may
+// replace or remove rules with the tag, but must keep the others.
+const AutomanagedTag = "automanaged"
+
+// WithSwig configures genrules which run SWIG.
+// "tool" is the label of the SWIG binary, or an empty string to run "swig"
+// in PATH. "goarch" is the target architecture of Go packages passed to
+// Generate, which determines the size of Go ints in wrappers. Variants for
+// other sizes get their own commands behind select().
+// By default, Generator runs "swig" in PATH for build.Default.GOARCH.
+func WithSwig(tool, goarch string) Option {
+	return func(g *generator) {
+		g.swigTool = tool
+		g.goarch = goarch
+	}
+}
+
+// swigFile describes how SWIG processes an interface file in a Go package,
+// following the convention of the go tool.
+type swigFile struct {
+	// src is the name of the interface file.
+	src string
+	// cxx is true if "src" is a C++ interface file.
+	cxx bool
+}
+
+// module returns the name of SWIG module generated from f.
+func (f swigFile) module() string {
+	return strings.TrimSuffix(f.src, filepath.Ext(f.src))
+}
+
+// goFile returns the name of the Go file generated from f.
+func (f swigFile) goFile() string {
+	return f.module() + "_swig.go"
+}
+
+// wrapper returns the name of the C or C++ wrapper file generated from f.
+func (f swigFile) wrapper() string {
+	if f.cxx {
+		return f.module() + "_wrap.cxx"
+	}
+	return f.module() + "_wrap.c"
+}
+
+// swigFiles returns SWIG interface files in "pkg".
+func swigFiles(pkg *build.Package) []swigFile {
+	var files []swigFile
+	for _, src := range pkg.SwigFiles {
+		files = append(files, swigFile{src: src})
+	}
+	for _, src := range pkg.SwigCXXFiles {
+		files = append(files, swigFile{src: src, cxx: true})
+	}
+	return files
+}
+
+// swigOutputs returns the files generated by SWIG from interface files in "pkg".
+func swigOutputs(pkg *build.Package) []string {
+	var outs []string
+	for _, f := range swigFiles(pkg) {
+		outs = append(outs, f.goFile(), f.wrapper())
+	}
+	return outs
+}
+
+// usesCgo returns true if the go tool builds "pkg" with cgo.
+// SWIG always requires cgo.
+func usesCgo(pkg *build.Package) bool {
+	return len(pkg.CgoFiles) > 0 || len(pkg.SwigFiles) > 0 || len(pkg.SwigCXXFiles) > 0
+}
+
+// intSize returns the size of Go ints in bits on "goarch".
+func intSize(goarch string) int64 {
+	sizes := types.SizesFor("gc", goarch)
+	if sizes == nil {
+		return 64
+	}
+	return sizes.Sizeof(types.Typ[types.Int]) * 8
+}
+
+// generateSwig generates genrules which run SWIG for interface files in
+// "pkg" and its variants "vpkgs".
+// Wrappers are generated for the size of Go ints on the target architecture
+// like the go tool does.
+func (g *generator) generateSwig(rel string, pkg *build.Package, vpkgs []*build.Package) ([]*bzl.Rule, error) {
+	seen := make(map[string]bool)
+	var files []swigFile
+	var headers []string
+	for _, p := range append([]*build.Package{pkg}, vpkgs...) {
+		if p == nil {
+			continue
+		}
+		for _, f := range swigFiles(p) {
+			if !seen[f.src] {
+				seen[f.src] = true
+				files = append(files, f)
+			}
+		}
+		for _, h := range p.HFiles {
+			if !seen[h] {
+				seen[h] = true
+				headers = append(headers, h)
+			}
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].src < files[j].src })
+	sort.Strings(headers)
+
+	// In FlatMode, files are relative to the top level of the repository.
+	outdir := "$(@D)"
+	file := func(name string) string { return name }
+	if g.mode == FlatMode && rel != "" {
+		outdir += "/" + rel
+		file = func(name string) string { return path.Join(rel, name) }
+	}
+
+	swig := "swig"
+	if g.swigTool != "" {
+		swig = fmt.Sprintf("$(location %s)", g.swigTool)
+	}
+	goarch := g.goarch
+	if goarch == "" {
+		goarch = build.Default.GOARCH
+	}
+
+	var rules []*bzl.Rule
+	for _, f := range files {
+		name := f.module() + "_swig"
+		if g.mode == FlatMode && rel != "" {
+			name = flatName(rel) + "/" + name
+		}
+		command := func(goarch string) string {
+			opts := fmt.Sprintf("-go -cgo -intgosize %d", intSize(goarch))
+			if f.cxx {
+				opts += " -c++"
+			}
+			return fmt.Sprintf("%s %s -module %s -outdir %s -o $(location %s) $(location %s) && mv %s/%s.go $(location %s)",
+				swig, opts, f.module(), outdir, file(f.wrapper()), file(f.src), outdir, f.module(), file(f.goFile()))
+		}
+		cmd := selectString{deflt: command(goarch)}
+		for _, v := range g.variants {
+			if intSize(v.Context.GOARCH) != intSize(goarch) {
+				cmd.cases = append(cmd.cases, selectCase{cond: v.Condition, values: []string{command(v.Context.GOARCH)}})
+			}
+		}
+		srcs := []string{file(f.src)}
+		for _, h := range headers {
+			srcs = append(srcs, file(h))
+		}
+		attrs := []keyvalue{
+			{key: "name", value: name},
+			{key: "srcs", value: srcs},
+			{key: "outs", value: []string{file(f.goFile()), file(f.wrapper())}},
+			{key: "cmd", value: cmd},
+		}
+		if g.swigTool != "" {
+			attrs = append(attrs, keyvalue{key: "tools", value: []string{g.swigTool}})
+		}
+		attrs = append(attrs, keyvalue{key: "tags", value: []string{AutomanagedTag}})
+		r, err := newRule("genrule", nil, attrs)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
@@ -0,0 +1,5 @@
+// Package asm is an example package implemented in assembly.
+package asm
+
+// Add returns the sum of x and y.
+func Add(x, y int64) int64
@@ -0,0 +1 @@
+// Macros shared by assembly files.
@@ -0,0 +1,13 @@
+// Package cdeps is an example cgo package depending on C libraries.
+package cdeps
+
+// #cgo pkg-config: zlib libunknown
+// #include <stdlib.h>
+// #include <zlib.h>
+// #include "foo/bar.h"
+import "C"
+
+// Version returns the version of zlib.
+// Outside of the preamble, the following line is not a directive.
+// #include "other/other.h"
+func Version() string { return C.GoString(C.zlibVersion()) }
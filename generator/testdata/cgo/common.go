@@ -0,0 +1,2 @@
+// Package cgo is an example package with both cgo and pure Go implementations.
+package cgo
@@ -0,0 +1 @@
+int native_answer(void) { return 42; }
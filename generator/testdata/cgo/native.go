@@ -0,0 +1,11 @@
+package cgo
+
+// #include <stdlib.h>
+import "C"
+
+import (
+	"example.com/repo/lib"
+)
+
+// Answer returns the answer.
+func Answer() int { return int(C.abs(C.int(lib.Answer()))) }
@@ -0,0 +1,6 @@
+//go:build !cgo
+
+package cgo
+
+// Answer returns the answer.
+func Answer() int { return 42 }
@@ -0,0 +1,12 @@
+package dotless
+
+import (
+	"fmt"
+
+	"mycompany/repo/lib"
+)
+
+// Print prints the answer.
+func Print() {
+	fmt.Println(lib.Answer())
+}
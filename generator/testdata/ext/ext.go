@@ -0,0 +1,13 @@
+package ext
+
+import (
+	"fmt"
+
+	"example.com/repo/lib"
+	"github.com/foo/bar/baz"
+)
+
+// Print prints the answer in a fancy way.
+func Print() {
+	fmt.Println(baz.Fancy(lib.Answer()))
+}
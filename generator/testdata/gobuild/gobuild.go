@@ -0,0 +1,2 @@
+// Package gobuild is an example package with //go:build constraints.
+package gobuild
@@ -0,0 +1,10 @@
+//go:build go1.9 && (linux || darwin)
+
+package gobuild
+
+import (
+	"example.com/repo/lib"
+)
+
+// Answer returns the answer.
+func Answer() int { return lib.Answer() }
@@ -0,0 +1,7 @@
+//go:build !go1.9 || !(linux || darwin)
+// +build !go1.9 !linux,!darwin
+
+package gobuild
+
+// Answer returns the answer.
+func Answer() int { return 42 }
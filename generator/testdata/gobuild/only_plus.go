@@ -0,0 +1,3 @@
+// +build gazel_never
+
+package gobuild
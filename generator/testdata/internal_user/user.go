@@ -0,0 +1,7 @@
+// Package user is an example package which imports internal packages.
+package user
+
+import (
+	_ "example.com/repo/internal_user/internal/own"
+	_ "example.com/repo/other/internal/secret"
+)
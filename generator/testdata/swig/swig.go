@@ -0,0 +1,2 @@
+// Package swig is an example package wrapping a C++ library with SWIG.
+package swig
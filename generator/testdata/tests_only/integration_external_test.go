@@ -0,0 +1,11 @@
+package tests_only_test
+
+import (
+	"testing"
+
+	"example.com/repo/tests_only"
+)
+
+func TestIntegrationExternal(t *testing.T) {
+	tests_only.TestIntegration(t)
+}
@@ -0,0 +1,13 @@
+package tests_only
+
+import (
+	"testing"
+
+	"example.com/repo/lib"
+)
+
+func TestIntegration(t *testing.T) {
+	if got, want := lib.Answer(), 42; got != want {
+		t.Errorf("lib.Answer() = %d; want %d", got, want)
+	}
+}
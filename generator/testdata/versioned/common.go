@@ -0,0 +1,2 @@
+// Package versioned is an example package with sources specific to releases of Go.
+package versioned
@@ -0,0 +1,10 @@
+// +build go1.9
+
+package versioned
+
+import (
+	"example.com/repo/lib"
+)
+
+// Answer returns the answer.
+func Answer() int { return lib.Answer() }
@@ -0,0 +1,6 @@
+// +build !go1.9
+
+package versioned
+
+// Answer returns the answer.
+func Answer() int { return 42 }
@@ -0,0 +1,13 @@
+package xtest_only_test
+
+import (
+	"testing"
+
+	"example.com/repo/lib"
+)
+
+func TestEndToEnd(t *testing.T) {
+	if got, want := lib.Answer(), 42; got != want {
+		t.Errorf("lib.Answer() = %d; want %d", got, want)
+	}
+}
@@ -0,0 +1,189 @@
+package generator
+
+import (
+	"go/build"
+)
+
+// A Variant is an alternative build configuration under which Generator
+// evaluates Go packages in addition to the one of the package passed to
+// Generate. Sources and dependencies specific to variants are put behind
+// select() in generated rules.
+type Variant struct {
+	// Condition is the label of a config_setting which selects the variant.
+	Condition string
+	// Context is the build context to evaluate Go packages with.
+	// Generator evaluates build constraints by ConstraintContext on the top of
+	// it, so it must not be the one returned by ConstraintContext.
+	Context build.Context
+}
+
+// WithVariants lets Generator evaluate Go packages under "variants" too.
+func WithVariants(variants []Variant) Option {
+	return func(g *generator) {
+		g.variants = append(g.variants, variants...)
+	}
+}
+
+// importVariants evaluates the Go package "pkg" under the variants of "g".
+// The i-th element of the returned slice corresponds to g.variants[i].
+// It is nil if the directory contains no Go files under the variant.
+// "c" is the configuration of the directory of "pkg".
+func (g *generator) importVariants(pkg *build.Package, c *Config) ([]*build.Package, error) {
+	var pkgs []*build.Package
+	for _, v := range g.variants {
+		bctx := c.Context(v.Context)
+		p, err := bctx.ImportDir(pkg.Dir, build.ImportComment)
+		if _, ok := err.(*build.NoGoError); ok {
+			p = nil
+		} else if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, nil
+}
+
+// varying applies "f" to "pkg" and its variants "vpkgs", and splits the
+// results into the common part and parts specific to variants.
+func (g *generator) varying(pkg *build.Package, vpkgs []*build.Package, f func(p *build.Package) []string) selectStrings {
+	var conds []string
+	var lists [][]string
+	for i, p := range vpkgs {
+		var list []string
+		if p != nil {
+			list = f(p)
+		}
+		conds = append(conds, g.variants[i].Condition)
+		lists = append(lists, list)
+	}
+	return splitStrings(f(pkg), conds, lists)
+}
+
+// selectStrings is a list of strings which can vary with conditions.
+type selectStrings struct {
+	// common is a list of strings which are common to all the conditions.
+	common []string
+	// cases is a list of strings specific to conditions.
+	cases []selectCase
+	// deflt is a list of strings specific to the default condition.
+	// It is meaningful only if "cases" is not empty.
+	deflt []string
+}
+
+type selectCase struct {
+	cond   string
+	values []string
+}
+
+// selectString is a string which can vary with conditions.
+type selectString struct {
+	// cases is a list of strings specific to conditions. Each of "values"
+	// has exactly one element.
+	cases []selectCase
+	// deflt is the string for the default condition.
+	deflt string
+}
+
+// mapStrings applies "f" to each list in "s".
+func (s selectStrings) mapStrings(f func(values []string) ([]string, error)) (selectStrings, error) {
+	common, err := f(s.common)
+	if err != nil {
+		return selectStrings{}, err
+	}
+	result := selectStrings{common: common}
+	for _, c := range s.cases {
+		values, err := f(c.values)
+		if err != nil {
+			return selectStrings{}, err
+		}
+		result.cases = append(result.cases, selectCase{cond: c.cond, values: values})
+	}
+	if result.deflt, err = f(s.deflt); err != nil {
+		return selectStrings{}, err
+	}
+	return result.simplify(), nil
+}
+
+// simplify removes cases which are the same as the default condition.
+func (s selectStrings) simplify() selectStrings {
+	var cases []selectCase
+	for _, c := range s.cases {
+		if !sameSet(c.values, s.deflt) {
+			cases = append(cases, c)
+		}
+	}
+	if len(cases) == 0 {
+		return selectStrings{common: append(s.common, s.deflt...)}
+	}
+	s.cases = cases
+	return s
+}
+
+// empty determines if "s" is empty under any condition.
+func (s selectStrings) empty() bool {
+	if len(s.common) > 0 || len(s.deflt) > 0 {
+		return false
+	}
+	for _, c := range s.cases {
+		if len(c.values) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// splitStrings builds a selectStrings from "base" for the default condition
+// and "lists" for "conds".
+// Conditions whose lists are the same as "base" fall back to the default.
+func splitStrings(base []string, conds []string, lists [][]string) selectStrings {
+	common := base
+	for _, list := range lists {
+		common = intersect(common, list)
+	}
+	s := selectStrings{common: common}
+	for i, list := range lists {
+		if sameSet(list, base) {
+			continue
+		}
+		s.cases = append(s.cases, selectCase{cond: conds[i], values: subtract(list, common)})
+	}
+	if len(s.cases) > 0 {
+		s.deflt = subtract(base, common)
+	}
+	return s
+}
+
+// intersect returns elements of "x" which "y" contains too.
+func intersect(x, y []string) []string {
+	set := make(map[string]bool)
+	for _, e := range y {
+		set[e] = true
+	}
+	var result []string
+	for _, e := range x {
+		if set[e] {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// subtract returns elements of "x" which "y" does not contain.
+func subtract(x, y []string) []string {
+	set := make(map[string]bool)
+	for _, e := range y {
+		set[e] = true
+	}
+	var result []string
+	for _, e := range x {
+		if !set[e] {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// sameSet determines if "x" and "y" contain the same elements.
+func sameSet(x, y []string) bool {
+	return len(subtract(x, y)) == 0 && len(subtract(y, x)) == 0
+}
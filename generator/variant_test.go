@@ -0,0 +1,61 @@
+package generator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStrings(t *testing.T) {
+	for _, spec := range []struct {
+		base  []string
+		conds []string
+		lists [][]string
+		want  selectStrings
+	}{
+		{
+			base: []string{"a", "b"},
+			want: selectStrings{common: []string{"a", "b"}},
+		},
+		{
+			base:  []string{"a", "b"},
+			conds: []string{"//c:x"},
+			lists: [][]string{{"b", "a"}},
+			want:  selectStrings{common: []string{"a", "b"}},
+		},
+		{
+			base:  []string{"a", "b"},
+			conds: []string{"//c:x", "//c:y"},
+			lists: [][]string{{"a", "c"}, {"a", "b"}},
+			want: selectStrings{
+				common: []string{"a"},
+				cases:  []selectCase{{cond: "//c:x", values: []string{"c"}}},
+				deflt:  []string{"b"},
+			},
+		},
+		{
+			base:  []string{"a"},
+			conds: []string{"//c:x"},
+			lists: [][]string{nil},
+			want: selectStrings{
+				cases: []selectCase{{cond: "//c:x"}},
+				deflt: []string{"a"},
+			},
+		},
+	} {
+		if got := splitStrings(spec.base, spec.conds, spec.lists); !reflect.DeepEqual(got, spec.want) {
+			t.Errorf("splitStrings(%q, %q, %q) = %#v; want %#v", spec.base, spec.conds, spec.lists, got, spec.want)
+		}
+	}
+}
+
+func TestSelectStringsSimplify(t *testing.T) {
+	s := selectStrings{
+		common: []string{"a"},
+		cases:  []selectCase{{cond: "//c:x", values: []string{"b"}}},
+		deflt:  []string{"b"},
+	}
+	want := selectStrings{common: []string{"a", "b"}}
+	if got := s.simplify(); !reflect.DeepEqual(got, want) {
+		t.Errorf("%#v.simplify() = %#v; want %#v", s, got, want)
+	}
+}
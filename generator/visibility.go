@@ -0,0 +1,48 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// internalParent returns the parent of the last "internal" element in the
+// slash-delimited path "p", and true if "p" has such an element.
+// Go allows only packages under the parent to import "p".
+func internalParent(p string) (string, bool) {
+	elems := strings.Split(p, "/")
+	for i := len(elems) - 1; i >= 0; i-- {
+		if elems[i] == "internal" {
+			return strings.Join(elems[:i], "/"), true
+		}
+	}
+	return "", false
+}
+
+// visibility returns the visibility of the library in the directory "rel".
+// Libraries under "internal" directories are visible only to packages under
+// the parents of the directories, and others are public.
+func (g *generator) visibility(rel string) []string {
+	parent, ok := internalParent(rel)
+	if !ok {
+		return []string{"//visibility:public"}
+	}
+	if g.mode == FlatMode && parent != "" {
+		// All the libraries are in the same Bazel package in FlatMode.
+		return []string{"//visibility:private"}
+	}
+	return []string{fmt.Sprintf("//%s:__subpackages__", parent)}
+}
+
+// checkInternal warns if the package in the directory "dir" imports
+// "importpath" against the restriction on internal packages.
+func (g *generator) checkInternal(importpath, dir string) {
+	parent, ok := internalParent(importpath)
+	if !ok {
+		return
+	}
+	if hasPathPrefix(g.importpath(dir), parent) {
+		return
+	}
+	msg := fmt.Sprintf("%s: use of internal package %q not allowed", dir, importpath)
+	g.warnOnce(msg, "%s", msg)
+}
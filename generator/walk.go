@@ -2,31 +2,31 @@ package generator
 
 import (
 	"go/build"
-	"os"
-	"path/filepath"
 )
 
 // A WalkFunc is a callback called by Walk for each package.
-type WalkFunc func(pkg *build.Package) error
+// "c" is the configuration of the directory of the package.
+type WalkFunc func(pkg *build.Package, c *Config) error
 
 // Walk walks through Go packages under the given dir.
 // It calls back "f" for each package.
-func Walk(bctx build.Context, root string, f WalkFunc) error {
-	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
+// "c" is the configuration of "root", or nil for the default one.
+// Walk applies directives in BUILD files in subdirectories as it descends,
+// and skips packages with "gazel:ignore".
+// Build constraints are evaluated as described in Config.Context.
+func Walk(bctx build.Context, root string, c *Config, f WalkFunc) error {
+	return walkConfigs(root, c, func(path string, c *Config) error {
+		if c != nil && c.Ignore {
 			return nil
 		}
-
-		pkg, err := bctx.ImportDir(path, build.ImportComment)
+		cctx := c.Context(bctx)
+		pkg, err := cctx.ImportDir(path, build.ImportComment)
 		if _, ok := err.(*build.NoGoError); ok {
 			return nil
 		}
 		if err != nil {
 			return err
 		}
-		return f(pkg)
+		return f(pkg, c)
 	})
 }
@@ -29,7 +29,7 @@ func TestWalkSimple(t *testing.T) {
 	}
 
 	var n int
-	err = generator.Walk(build.Default, dir, func(pkg *build.Package) error {
+	err = generator.Walk(build.Default, dir, nil, func(pkg *build.Package, c *generator.Config) error {
 		if got, want := pkg.Name, "lib"; got != want {
 			t.Errorf("pkg.Name = %q; want %q", got, want)
 		}
@@ -37,7 +37,7 @@ func TestWalkSimple(t *testing.T) {
 		return nil
 	})
 	if err != nil {
-		t.Errorf("generator.Walk(build.Default, %q, func) failed with %v; want success", dir, err)
+		t.Errorf("generator.Walk(build.Default, %q, nil, func) failed with %v; want success", dir, err)
 	}
 	if got, want := n, 1; got != want {
 		t.Errorf("n = %d; want %d", got, want)
@@ -68,7 +68,7 @@ func TestWalkNested(t *testing.T) {
 	}
 
 	var dirs, pkgs []string
-	err = generator.Walk(build.Default, dir, func(pkg *build.Package) error {
+	err = generator.Walk(build.Default, dir, nil, func(pkg *build.Package, c *generator.Config) error {
 		rel, err := filepath.Rel(dir, pkg.Dir)
 		if err != nil {
 			t.Errorf("filepath.Rel(%q, %q) failed with %v; want success", dir, pkg.Dir, err)
@@ -79,7 +79,7 @@ func TestWalkNested(t *testing.T) {
 		return nil
 	})
 	if err != nil {
-		t.Errorf("generator.Walk(build.Default, %q, func) failed with %v; want success", dir, err)
+		t.Errorf("generator.Walk(build.Default, %q, nil, func) failed with %v; want success", dir, err)
 	}
 
 	sort.Strings(dirs)
@@ -91,3 +91,95 @@ func TestWalkNested(t *testing.T) {
 		t.Errorf("pkgs = %q; want %q", got, want)
 	}
 }
+
+func TestWalkWithDirectives(t *testing.T) {
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, p := range []struct {
+		path, content string
+	}{
+		{path: "BUILD", content: "# gazel:exclude skipped\n# gazel:exclude broken.go\n# gazel:exclude ignored/tagged/broken.go\n"},
+		{path: "lib.go", content: "package lib"},
+		{path: "broken.go", content: "package broken"},
+		{path: "skipped/skipped.go", content: "package skipped"},
+		{path: "ignored/BUILD", content: "# gazel:ignore\n# gazel:build_tags extra\n"},
+		{path: "ignored/ignored.go", content: "package ignored"},
+		{path: "ignored/tagged/tagged.go", content: "// +build extra\n\npackage tagged"},
+		{path: "ignored/tagged/broken.go", content: "package broken"},
+	} {
+		path := filepath.Join(dir, p.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("os.MkdirAll(%q, 0700) failed with %v; want success", filepath.Dir(path), err)
+		}
+		if err := ioutil.WriteFile(path, []byte(p.content), 0600); err != nil {
+			t.Fatalf("ioutil.WriteFile(%q, %q, 0600) failed with %v; want success", path, p.content, err)
+		}
+	}
+
+	c, err := generator.LoadConfig(dir, nil)
+	if err != nil {
+		t.Fatalf("generator.LoadConfig(%q, nil) failed with %v; want success", dir, err)
+	}
+	var pkgs []string
+	err = generator.Walk(build.Default, dir, c, func(pkg *build.Package, c *generator.Config) error {
+		pkgs = append(pkgs, pkg.Name)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("generator.Walk(build.Default, %q, c, func) failed with %v; want success", dir, err)
+	}
+	sort.Strings(pkgs)
+	if got, want := pkgs, []string{"lib", "tagged"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pkgs = %q; want %q", got, want)
+	}
+}
+
+func TestWalkWithBuildTags(t *testing.T) {
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, p := range []struct {
+		path, content string
+	}{
+		{path: "p/BUILD", content: "# gazel:build_tags extra\n"},
+		{path: "p/p.go", content: "package p"},
+		{path: "p/tagged.go", content: "// +build extra\n\npackage p"},
+		{path: "p/gobuild.go", content: "//go:build extra && !other\n\npackage p"},
+		{path: "p/other.go", content: "// +build other\n\npackage p"},
+		{path: "p/sub/sub.go", content: "package sub"},
+		{path: "p/sub/tagged.go", content: "// +build extra\n\npackage sub"},
+	} {
+		path := filepath.Join(dir, p.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("os.MkdirAll(%q, 0700) failed with %v; want success", filepath.Dir(path), err)
+		}
+		if err := ioutil.WriteFile(path, []byte(p.content), 0600); err != nil {
+			t.Fatalf("ioutil.WriteFile(%q, %q, 0600) failed with %v; want success", path, p.content, err)
+		}
+	}
+
+	// The base context evaluates constraints by ConstraintContext, which
+	// must take the tags in the directives into account.
+	files := make(map[string][]string)
+	err = generator.Walk(build.Default, dir, nil, func(pkg *build.Package, c *generator.Config) error {
+		files[pkg.Name] = pkg.GoFiles
+		return nil
+	})
+	if err != nil {
+		t.Errorf("generator.Walk(build.Default, %q, nil, func) failed with %v; want success", dir, err)
+	}
+	want := map[string][]string{
+		"p":   {"gobuild.go", "p.go", "tagged.go"},
+		"sub": {"sub.go", "tagged.go"},
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("files = %q; want %q", files, want)
+	}
+}